@@ -0,0 +1,350 @@
+// Filename: cmd/api/attachments.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/storage"
+	"toaster.jalen.net/internals/validator"
+)
+
+var errFileTooLarge = errors.New("file too large")
+
+// listAttachmentsHandler for the "GET /v1/toasts/:id/attachments" endpoint
+func (app *application) listAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Make sure the toast exists, so a toast without attachments isn't
+	// confused with a missing one
+	_, err = app.models.Toasts.GetFields(toastID, []string{"id"})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	attachments, err := app.models.Attachments.GetAllForToast(toastID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, attachment := range attachments {
+		attachment.URL = app.attachmentURL(attachment)
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"attachments": attachments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAttachmentHandler for the "POST /v1/toasts/:id/attachments"
+// endpoint. The body is a multipart form with the file in its "file" part.
+// The file is streamed to storage rather than read into memory
+func (app *application) createAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Check for the toast before reading a possibly large body
+	_, err = app.models.Toasts.GetFields(toastID, []string{"id"})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if app.readMediaType(r) != "multipart/form-data" {
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+	maxBytes := app.config.attachments.maxBytes
+	// Leave some room for the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+65_536)
+	part, err := app.readFilePart(r, "file")
+	if err != nil {
+		app.uploadErrorResponse(w, r, err)
+		return
+	}
+	// Sniff the content type from the start of the file rather than
+	// trusting the client
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		app.uploadErrorResponse(w, r, err)
+		return
+	}
+	head = head[:n]
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	attachment := &data.Attachment{
+		ToastID:     toastID,
+		Filename:    part.FileName(),
+		ContentType: contentType,
+		Size:        int64(n),
+	}
+	v := validator.New()
+	if data.ValidateAttachment(v, attachment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	attachment.StorageKey, err = newStorageKey(toastID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	file := &uploadReader{r: io.MultiReader(bytes.NewReader(head), part), limit: maxBytes}
+	err = app.storage.Put(r.Context(), attachment.StorageKey, file, attachment.ContentType)
+	if err != nil {
+		// Failures reading the upload are the client's, anything else is ours
+		if file.err != nil {
+			app.uploadErrorResponse(w, r, file.err)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	attachment.Size = file.n
+	err = app.models.Attachments.Insert(attachment)
+	if err != nil {
+		app.deleteStoredFiles(attachment.StorageKey)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	attachment.URL = app.attachmentURL(attachment)
+	// Create a Location header for the new attachment
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/toasts/%d/attachments/%d", toastID, attachment.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"attachment": attachment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showAttachmentHandler for the "GET /v1/toasts/:id/attachments/:attachment_id" endpoint
+func (app *application) showAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	id, err := app.readAttachmentIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	attachment, err := app.models.Attachments.Get(toastID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	attachment.URL = app.attachmentURL(attachment)
+	err = app.writeJSON(w, http.StatusOK, envelope{"attachment": attachment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAttachmentHandler for the "DELETE /v1/toasts/:id/attachments/:attachment_id" endpoint
+func (app *application) deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	id, err := app.readAttachmentIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	key, err := app.models.Attachments.Delete(toastID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.deleteStoredFiles(key)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "attachment successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// downloadAttachmentHandler for the "GET /v1/attachments/:id/download"
+// endpoint. The signature in the URL stands in for authentication, so
+// links can be handed to browsers and other clients
+func (app *application) downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	qs := r.URL.Query()
+	expires, err := strconv.ParseInt(qs.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires ||
+		!hmac.Equal([]byte(qs.Get("signature")), []byte(app.signAttachment(id, expires))) {
+		app.invalidDownloadLinkResponse(w, r)
+		return
+	}
+	attachment, err := app.models.Attachments.Get(0, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	file, err := app.storage.Open(r.Context(), attachment.StorageKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private")
+	w.WriteHeader(http.StatusOK)
+	// The status has been sent, so a failure part way can only be logged
+	_, err = io.Copy(w, file)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The readFilePart() method returns the part of a multipart request body
+// with the form name, skipping the parts before it
+func (app *application) readFilePart(r *http.Request, name string) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("body must contain a %q part", name)
+			}
+			return nil, err
+		}
+		if part.FormName() == name {
+			return part, nil
+		}
+	}
+}
+
+// An uploadReader reads a file being uploaded. It counts the bytes read,
+// stops once there are more than limit, and keeps the error it stopped on
+type uploadReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+	err   error
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	u.n += int64(n)
+	if u.n > u.limit {
+		u.err = errFileTooLarge
+		return n, u.err
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		u.err = err
+	}
+	return n, err
+}
+
+// The newStorageKey() function returns a random, unguessable key to store a
+// toast's new attachment under
+func newStorageKey(toastID int64) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("toasts/%d/%s", toastID, hex.EncodeToString(b)), nil
+}
+
+// The attachmentURL() method returns a signed link to download an
+// attachment, which works until it expires
+func (app *application) attachmentURL(attachment *data.Attachment) string {
+	expires := time.Now().Add(app.config.attachments.urlTTL).Unix()
+	return fmt.Sprintf("/v1/attachments/%d/download?expires=%d&signature=%s",
+		attachment.ID, expires, app.signAttachment(attachment.ID, expires))
+}
+
+// The signAttachment() method returns the signature of a download link
+func (app *application) signAttachment(id int64, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(app.config.attachments.secret))
+	fmt.Fprintf(mac, "%d:%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// The deleteStoredFiles() method removes files from storage in the
+// background. A file left behind is only logged
+func (app *application) deleteStoredFiles(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	app.background(func() {
+		for _, key := range keys {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := app.storage.Delete(ctx, key)
+			cancel()
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"storage_key": key})
+			}
+		}
+	})
+}
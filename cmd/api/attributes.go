@@ -0,0 +1,95 @@
+// Filename: cmd/api/attributes.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/validator"
+)
+
+// listAttributesHandler for the "GET /v1/toasts/attributes" endpoint
+func (app *application) listAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	schemas, err := app.models.Attributes.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"attributes": schemas}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// putAttributeHandler for the "PUT /v1/toasts/attributes/:name" endpoint.
+// It creates the attribute schema or replaces it. A schema that stored
+// toasts don't meet is rejected, so they have to be updated first
+func (app *application) putAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Type     string   `json:"type"`
+		Required bool     `json:"required"`
+		Enum     []string `json:"enum"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	schema := &data.AttributeSchema{
+		Name:     app.readNameParam(r),
+		Type:     input.Type,
+		Required: input.Required,
+		Enum:     input.Enum,
+	}
+	v := validator.New()
+	if data.ValidateAttributeSchema(v, schema); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	created, violating, err := app.models.Attributes.Put(schema)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAttributeViolated):
+			v.AddError("attribute", fmt.Sprintf("stored toasts don't meet this schema (%d of them), update them first", violating))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.expireVocabulary()
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	err = app.writeJSON(w, status, envelope{"attribute": schema}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAttributeHandler for the "DELETE /v1/toasts/attributes/:name"
+// endpoint. Attributes toasts have a value for are kept
+func (app *application) deleteAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Attributes.Delete(app.readNameParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAttributeInUse):
+			message := "the attribute is used by toasts, remove it from them before deleting it"
+			app.errorResponse(w, r, http.StatusConflict, message)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.expireVocabulary()
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "attribute successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,57 @@
+// Filename: cmd/api/changes.go
+
+package main
+
+import (
+	"net/http"
+
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/validator"
+)
+
+// listChangesHandler for the "GET /v1/toasts/changes" endpoint. It returns
+// the changes to toasts after the since token, oldest first, and the token
+// to ask for the ones after them. Without a token the changes start from
+// the beginning, so applying them all gives every toast. A deleted toast's
+// change holds the toast as it was removed
+func (app *application) listChangesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Since string
+		Limit int
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Since = app.readString(qs, "since", "")
+	input.Limit = app.readInt(qs, "limit", 100, v)
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 1000, "limit", "must be a maximum of 1000")
+	since, err := data.DecodeChangeToken(input.Since)
+	if err != nil {
+		v.AddError("since", "must be a token from an earlier response")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Read one more than asked for to tell whether there are more
+	changes, err := app.models.Events.GetAfter(since, input.Limit+1)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	more := len(changes) > input.Limit
+	if more {
+		changes = changes[:input.Limit]
+	}
+	if len(changes) > 0 {
+		since = changes[len(changes)-1].Position
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"changes":  changes,
+		"next":     data.EncodeChangeToken(since),
+		"has_more": more,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
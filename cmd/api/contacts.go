@@ -0,0 +1,262 @@
+// Filename: cmd/api/contacts.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/phone"
+	"toaster.jalen.net/internals/validator"
+)
+
+// listContactsHandler for the "GET /v1/toasts/:id/contacts" endpoint
+func (app *application) listContactsHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Make sure the toast exists, so a toast without contacts isn't
+	// confused with a missing one
+	_, err = app.models.Toasts.GetFields(toastID, []string{"id"})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	contacts, err := app.models.Contacts.GetAllForToast(toastID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"contacts": contacts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createContactHandler for the "POST /v1/toasts/:id/contacts" endpoint
+func (app *application) createContactHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Our target decode destination
+	var input struct {
+		Name    string `json:"name"`
+		Role    string `json:"role"`
+		Phone   string `json:"phone"`
+		Email   string `json:"email"`
+		Primary bool   `json:"primary"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	contact := &data.Contact{
+		ToastID: toastID,
+		Name:    input.Name,
+		Role:    input.Role,
+		Phone:   input.Phone,
+		Email:   input.Email,
+		Primary: input.Primary,
+	}
+	v := validator.New()
+	app.normalizeContactPhone(contact)
+	if data.ValidateContact(v, contact); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Contacts.Insert(contact)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Create a Location header for the new contact
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/toasts/%d/contacts/%d", toastID, contact.ID))
+	headers.Set("ETag", etag(contact.Version))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"contact": contact}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showContactHandler for the "GET /v1/toasts/:id/contacts/:contact_id" endpoint
+func (app *application) showContactHandler(w http.ResponseWriter, r *http.Request) {
+	contact, ok := app.readContact(w, r)
+	if !ok {
+		return
+	}
+	// Send the entity tag, which the client needs to update the contact
+	headers := make(http.Header)
+	headers.Set("ETag", etag(contact.Version))
+	err := app.writeJSON(w, http.StatusOK, envelope{"contact": contact}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateContactHandler for the "PATCH /v1/toasts/:id/contacts/:contact_id"
+// endpoint. It does a partial replacement
+func (app *application) updateContactHandler(w http.ResponseWriter, r *http.Request) {
+	contact, ok := app.readContact(w, r)
+	if !ok {
+		return
+	}
+	// The client must tell us which version it is editing, as for a toast
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		app.preconditionRequiredResponse(w, r)
+		return
+	}
+	if !etagMatches(match, etag(contact.Version), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	// Use pointers so we can tell which fields were sent
+	var input struct {
+		Name    *string `json:"name"`
+		Role    *string `json:"role"`
+		Phone   *string `json:"phone"`
+		Email   *string `json:"email"`
+		Primary *bool   `json:"primary"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	// Check for updates
+	if input.Name != nil {
+		contact.Name = *input.Name
+	}
+	if input.Role != nil {
+		contact.Role = *input.Role
+	}
+	if input.Phone != nil {
+		contact.Phone = *input.Phone
+	}
+	if input.Email != nil {
+		contact.Email = *input.Email
+	}
+	v := validator.New()
+	// A toast's contact details are its primary contact's, so it always
+	// needs one. Making another contact primary takes over instead
+	if input.Primary != nil {
+		v.Check(*input.Primary || !contact.Primary, "primary", "can't be unset, make another contact primary instead")
+		contact.Primary = *input.Primary
+	}
+	app.normalizeContactPhone(contact)
+	if data.ValidateContact(v, contact); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Contacts.Update(contact)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Send the new entity tag along with the updated contact
+	headers := make(http.Header)
+	headers.Set("ETag", etag(contact.Version))
+	err = app.writeJSON(w, http.StatusOK, envelope{"contact": contact}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteContactHandler for the "DELETE /v1/toasts/:id/contacts/:contact_id" endpoint
+func (app *application) deleteContactHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	id, err := app.readContactIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Contacts.Delete(toastID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrPrimaryContact):
+			v := validator.New()
+			v.AddError("primary", "the primary contact can't be deleted, make another contact primary first")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "contact successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readContact() method fetches the contact named by the request path.
+// It sends the error response and returns false when there is none
+func (app *application) readContact(w http.ResponseWriter, r *http.Request) (*data.Contact, bool) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	id, err := app.readContactIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	contact, err := app.models.Contacts.Get(toastID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return contact, true
+}
+
+// The normalizeContactPhone() method works like normalizePhone() for a
+// contact, whose phone number is optional. The number is kept as typed
+func (app *application) normalizeContactPhone(contact *data.Contact) {
+	contact.Phone = strings.TrimSpace(contact.Phone)
+	contact.PhoneE164 = ""
+	if contact.Phone == "" {
+		return
+	}
+	number, err := phone.Parse(contact.Phone, app.config.phone.region)
+	if err != nil {
+		return
+	}
+	contact.PhoneE164 = number.E164
+}
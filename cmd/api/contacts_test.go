@@ -0,0 +1,118 @@
+// Filename: cmd/api/contacts_test.go
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/julienschmidt/httprouter"
+)
+
+// The queries the contact handlers run
+const (
+	getContactQuery    = `SELECT id, toast_id, created_at, name, role, phone, phone_e164, email, is_primary, version\s+FROM toast_contacts`
+	updateContactQuery = `UPDATE toast_contacts\s+SET name = \$1`
+)
+
+// The contactRequest() function returns a request for contact 3 of toast 7
+func contactRequest(method string, body string) *http.Request {
+	r := httptest.NewRequest(method, "/v1/toasts/7/contacts/3", strings.NewReader(body))
+	params := httprouter.Params{{Key: "id", Value: "7"}, {Key: "contact_id", Value: "3"}}
+	return r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+}
+
+// The contactRows() function returns contact 3 of toast 7 at version 2, a
+// contact that isn't the primary one
+func contactRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "toast_id", "created_at", "name", "role", "phone", "phone_e164", "email", "is_primary", "version"}).
+		AddRow(3, 7, time.Now(), "John Smith", "Registrar", "", "", "registrar@sjc.edu.bz", false, 2)
+}
+
+func TestShowContactSendsETag(t *testing.T) {
+	app, mock, _ := newMockApp(t)
+	mock.ExpectQuery(getContactQuery).WithArgs(int64(7), int64(3)).WillReturnRows(contactRows())
+
+	w := httptest.NewRecorder()
+	app.showContactHandler(w, contactRequest("GET", ""))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("ETag is %s, want \"2\"", got)
+	}
+}
+
+func TestUpdateContactIfMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		ifMatch    string
+		update     func(sqlmock.Sqlmock)
+		wantStatus int
+		wantETag   string
+	}{
+		{
+			name:       "no If-Match",
+			wantStatus: http.StatusPreconditionRequired,
+		},
+		{
+			name:       "stale version",
+			ifMatch:    `"1"`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			// Another client updated the contact after it was read
+			name:    "changed while updating",
+			ifMatch: `"2"`,
+			update: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(updateContactQuery).WillReturnRows(sqlmock.NewRows([]string{"version"}))
+				mock.ExpectRollback()
+			},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:    "current version",
+			ifMatch: `"2"`,
+			update: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(updateContactQuery).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusOK,
+			wantETag:   `"3"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := newMockApp(t)
+			mock.ExpectQuery(getContactQuery).WithArgs(int64(7), int64(3)).WillReturnRows(contactRows())
+			if tt.update != nil {
+				tt.update(mock)
+			}
+
+			w := httptest.NewRecorder()
+			r := contactRequest("PATCH", `{"role": "Head Registrar"}`)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			app.updateContactHandler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("ETag is %q, want %q", got, tt.wantETag)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -23,7 +23,13 @@ func (app *application) contextSetUser(r *http.Request, user *data.User) *http.R
 
 // Retreive the User struct
 func (app *application) contextGetUser(r *http.Request) *data.User {
-	user, ok := r.Context().Value(userContextKey).(*data.User)
+	return contextUser(r.Context())
+}
+
+// The contextUser() function retrieves the User struct from a context, for
+// the GraphQL resolvers and gRPC methods
+func contextUser(ctx context.Context) *data.User {
+	user, ok := ctx.Value(userContextKey).(*data.User)
 	if !ok {
 		panic("missing user value in request context")
 	}
@@ -0,0 +1,107 @@
+// Filename: cmd/api/duplicates.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/validator"
+)
+
+// listDuplicatesHandler for the "GET /v1/toasts/duplicates" endpoint. It
+// reports the pairs of toasts that look like the same institution
+func (app *application) listDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	// Get the page information. The pairs have a fixed order
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "id"
+	input.Filters.SortList = []string{"id"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	pairs, metadata, err := app.models.Toasts.GetDuplicates(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"duplicates": pairs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeToastsHandler for the "POST /v1/toasts/:id/merge" endpoint. The
+// toast named in the body is combined into the toast in the path and
+// removed
+func (app *application) mergeToastsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		DuplicateID int64 `json:"duplicate_id"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.DuplicateID > 0, "duplicate_id", "must be provided")
+	v.Check(input.DuplicateID != id, "duplicate_id", "must not be the toast being merged into")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Fetch the toast that is kept
+	toast, err := app.models.Toasts.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The client must tell us which version of the kept toast it is
+	// merging into
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		app.preconditionRequiredResponse(w, r)
+		return
+	}
+	if !etagMatches(match, etag(toast.Version), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	err = app.models.Toasts.Merge(toast, input.DuplicateID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("duplicate_id", "must be an existing toast")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Send the new entity tag
+	headers := make(http.Header)
+	headers.Set("ETag", etag(toast.Version))
+	err = app.writeJSON(w, http.StatusOK, envelope{"toast": toast}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 )
@@ -51,17 +52,60 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
+// The request body is in a format we do not accept
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The upload could not be read. A file over the size limit gets its own
+// status code
+func (app *application) uploadErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errFileTooLarge) || err.Error() == "http: request body too large" {
+		message := fmt.Sprintf("file must not be larger than %d bytes", app.config.attachments.maxBytes)
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+		return
+	}
+	app.badRequestResponse(w, r, err)
+}
+
 // Validation error
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+// The new record looks like records we already have. The candidates are
+// sent along so the client can check them
+func (app *application) duplicateToastResponse(w http.ResponseWriter, r *http.Request, duplicates interface{}) {
+	env := envelope{
+		"error":      "the toast looks like an existing toast, resend with force=true to create it anyway",
+		"duplicates": duplicates,
+	}
+	err := app.writeJSON(w, http.StatusConflict, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 // Edit Conflict error
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// The client's If-Match header does not match the current version
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since you last retrieved it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// The client did not send an If-Match header on a conditional request
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
 // Rate limit error
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
@@ -93,6 +137,12 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// The signature of a download link is wrong or it has expired
+func (app *application) invalidDownloadLinkResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the download link is invalid or has expired, please fetch a new one"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // User does not have the required permission (read/write)
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account does not have the necessary permissions to access this resource"
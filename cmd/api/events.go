@@ -0,0 +1,198 @@
+// Filename: cmd/api/events.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"toaster.jalen.net/internals/data"
+)
+
+// Settings for the event stream. A stream ends streamMargin before the
+// server's write timeout would cut it off, and the client reconnects after
+// streamRetry, resuming from the last event it got
+const (
+	streamHeartbeat = 15 * time.Second
+	streamMargin    = 5 * time.Second
+	streamRetry     = time.Second
+	streamBatch     = 100
+)
+
+// The streamHub wakes the open event streams when toasts change
+type streamHub struct {
+	mu      sync.Mutex
+	streams map[chan struct{}]struct{}
+}
+
+// The subscribe() method returns a channel that receives a value after
+// toasts change. A stream that is busy misses none: one value stands for
+// any number of changes
+func (h *streamHub) subscribe() chan struct{} {
+	wake := make(chan struct{}, 1)
+	h.mu.Lock()
+	if h.streams == nil {
+		h.streams = make(map[chan struct{}]struct{})
+	}
+	h.streams[wake] = struct{}{}
+	h.mu.Unlock()
+	return wake
+}
+
+// The unsubscribe() method stops waking a stream
+func (h *streamHub) unsubscribe(wake chan struct{}) {
+	h.mu.Lock()
+	delete(h.streams, wake)
+	h.mu.Unlock()
+}
+
+// The broadcast() method wakes every stream
+func (h *streamHub) broadcast() {
+	h.mu.Lock()
+	for wake := range h.streams {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	h.mu.Unlock()
+}
+
+// The listenToastChanges() method waits for the notifications the toasts
+// table's trigger sends and wakes the streams, until the server shuts down
+func (app *application) listenToastChanges() {
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	defer listener.Close()
+	err := listener.Listen("toast_changes")
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-app.shutdown:
+			return
+		case <-listener.Notify:
+			// A nil notification after a reconnect also wakes them, as
+			// changes may have been missed in between
+			app.streams.broadcast()
+		case <-ping.C:
+			// Check the connection is still there
+			go listener.Ping()
+		}
+	}
+}
+
+// streamToastEventsHandler for the "GET /v1/toasts/events" endpoint. It
+// sends the changes to toasts as Server-Sent Events, from the one after the
+// Last-Event-ID header (or last_event_id parameter) or else from now on
+func (app *application) streamToastEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("the response writer can't stream"))
+		return
+	}
+	last, ok, err := app.readLastEventID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !ok {
+		last, err = app.models.Events.Latest()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	// Subscribe before reading the events so no change is missed
+	wake := app.streams.subscribe()
+	defer app.streams.unsubscribe(wake)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, err = fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+
+	deadline := time.Now().Add(writeTimeout - streamMargin)
+	end := time.NewTimer(time.Until(deadline))
+	defer end.Stop()
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		// Send everything new, in batches
+		for {
+			events, err := app.models.Events.GetAfter(last, streamBatch)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				return
+			}
+			for _, event := range events {
+				js, err := json.Marshal(event)
+				if err != nil {
+					app.logger.PrintError(err, nil)
+					return
+				}
+				// The event id is its position, as the token of the changes feed
+				_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", data.EncodeChangeToken(event.Position), event.Type, js)
+				if err != nil {
+					return
+				}
+				last = event.Position
+			}
+			if len(events) < streamBatch || time.Now().After(deadline) {
+				break
+			}
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.shutdown:
+			return
+		case <-end.C:
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			// Keeps proxies from closing the connection. Changes whose
+			// notification was lost, or that were held back by an older
+			// transaction, are picked up here too
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// The readLastEventID() method returns the position of the last event a
+// client got, and whether it sent one
+func (app *application) readLastEventID(r *http.Request) (data.EventPosition, bool, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+	if s == "" {
+		return data.EventPosition{}, false, nil
+	}
+	position, err := data.DecodeChangeToken(s)
+	if err != nil {
+		return data.EventPosition{}, false, errors.New("invalid last event id")
+	}
+	return position, true, nil
+}
@@ -0,0 +1,842 @@
+// Filename: cmd/api/graphql.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/validator"
+)
+
+// Limits on GraphQL queries. A query's cost is its number of fields, with
+// the fields under a list counted once for each item the list can hold
+const (
+	graphqlMaxDepth      = 15
+	graphqlMaxComplexity = 1000
+)
+
+// The arguments that give the size of list fields, and the size assumed
+// when they are left out
+var graphqlListSizes = map[string]struct {
+	arg         string
+	defaultSize int
+}{
+	"toasts":  {"page_size", 20},
+	"history": {"limit", 20},
+}
+
+// graphqlHandler for the "GET /v1/graphql" and "POST /v1/graphql" endpoints.
+// Each resolver checks the permissions it needs, so a query can fetch
+// whatever the user may see. Mutations must be sent with POST
+func (app *application) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if r.Method == http.MethodGet {
+		qs := r.URL.Query()
+		input.Query = qs.Get("query")
+		input.OperationName = qs.Get("operationName")
+		if variables := qs.Get("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &input.Variables)
+			if err != nil {
+				app.badRequestResponse(w, r, errors.New("variables must be a JSON object"))
+				return
+			}
+		}
+	} else {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	// Parse and check the query before running any of it
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(input.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		app.graphqlErrorResponse(w, r, http.StatusBadRequest, gqlerrors.FormatErrors(err))
+		return
+	}
+	validation := graphql.ValidateDocument(&app.graphql, doc, nil)
+	if !validation.IsValid {
+		app.graphqlErrorResponse(w, r, http.StatusBadRequest, validation.Errors)
+		return
+	}
+	operation := graphqlOperation(doc, input.OperationName)
+	if operation == nil {
+		message := "the operation to run must be named"
+		if input.OperationName != "" {
+			message = fmt.Sprintf("there is no operation named %q", input.OperationName)
+		}
+		app.graphqlErrorResponse(w, r, http.StatusBadRequest, gqlerrors.FormatErrors(errors.New(message)))
+		return
+	}
+	if operation.Operation == ast.OperationTypeMutation && r.Method != http.MethodPost {
+		message := "mutations must be sent with the POST method"
+		app.graphqlErrorResponse(w, r, http.StatusMethodNotAllowed, gqlerrors.FormatErrors(errors.New(message)))
+		return
+	}
+	depth, cost := graphqlCost(doc, operation, input.Variables)
+	if depth > graphqlMaxDepth {
+		message := fmt.Sprintf("the query must not nest fields more than %d deep", graphqlMaxDepth)
+		app.graphqlErrorResponse(w, r, http.StatusBadRequest, gqlerrors.FormatErrors(errors.New(message)))
+		return
+	}
+	if cost > graphqlMaxComplexity {
+		message := fmt.Sprintf("the query is too expensive, its cost must be at most %d", graphqlMaxComplexity)
+		app.graphqlErrorResponse(w, r, http.StatusBadRequest, gqlerrors.FormatErrors(errors.New(message)))
+		return
+	}
+	// The resolvers share the user's permissions, which are read once
+	ctx := context.WithValue(r.Context(), graphqlPermissionsKey, &graphqlPermissions{})
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        app.graphql,
+		AST:           doc,
+		OperationName: input.OperationName,
+		Args:          input.Variables,
+		Context:       ctx,
+	})
+	env := envelope{"data": result.Data}
+	if len(result.Errors) > 0 {
+		env["errors"] = result.Errors
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The graphqlErrorResponse() method sends errors found before the query ran
+// in the shape GraphQL clients expect
+func (app *application) graphqlErrorResponse(w http.ResponseWriter, r *http.Request, status int, errs []gqlerrors.FormattedError) {
+	err := app.writeJSON(w, status, envelope{"errors": errs}, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// The graphqlOperation() function returns the operation of the document to
+// run. The name can be left out when there is only one
+func graphqlOperation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = operation
+		} else if operation.Name != nil && operation.Name.Value == name {
+			return operation
+		}
+	}
+	return found
+}
+
+// The graphqlCost() function returns how deeply the fields of an operation
+// nest and what the operation costs. It stops counting once either is over
+// its limit, so a query can't make the counting itself expensive
+func graphqlCost(doc *ast.Document, operation *ast.OperationDefinition, variables map[string]interface{}) (int, int) {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, definition := range doc.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+	maxDepth, visited := 0, 0
+	var walk func(set *ast.SelectionSet, depth int) int
+	walk = func(set *ast.SelectionSet, depth int) int {
+		if set == nil {
+			return 0
+		}
+		cost := 0
+		for _, selection := range set.Selections {
+			if cost > graphqlMaxComplexity || maxDepth > graphqlMaxDepth || visited > graphqlMaxComplexity {
+				break
+			}
+			switch selection := selection.(type) {
+			case *ast.Field:
+				visited++
+				if depth > maxDepth {
+					maxDepth = depth
+				}
+				cost += 1 + graphqlListSize(selection, variables)*walk(selection.SelectionSet, depth+1)
+			case *ast.InlineFragment:
+				cost += walk(selection.SelectionSet, depth)
+			case *ast.FragmentSpread:
+				if fragment, ok := fragments[selection.Name.Value]; ok {
+					cost += walk(fragment.SelectionSet, depth)
+				}
+			}
+		}
+		return cost
+	}
+	cost := walk(operation.SelectionSet, 1)
+	return maxDepth, cost
+}
+
+// The graphqlListSize() function returns how many items a field's value
+// can hold: 1 unless it is a list
+func graphqlListSize(field *ast.Field, variables map[string]interface{}) int {
+	size, ok := graphqlListSizes[field.Name.Value]
+	if !ok {
+		return 1
+	}
+	n := size.defaultSize
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != size.arg {
+			continue
+		}
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			if i, err := strconv.Atoi(value.Value); err == nil {
+				n = i
+			}
+		case *ast.Variable:
+			if f, ok := variables[value.Name.Value].(float64); ok {
+				n = int(f)
+			}
+		}
+	}
+	// A size too big or small is rejected when the field is resolved
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// A graphqlError is an error a resolver reports to the client, with a code
+// the client can act on
+type graphqlError struct {
+	message    string
+	extensions map[string]interface{}
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	return e.extensions
+}
+
+// The newGraphQLError() function returns a graphqlError with the given code
+func newGraphQLError(code string, message string) *graphqlError {
+	return &graphqlError{message: message, extensions: map[string]interface{}{"code": code}}
+}
+
+// The graphqlServerError() method logs an unexpected error and returns the
+// error the client is told about instead
+func (app *application) graphqlServerError(err error) error {
+	app.logger.PrintError(err, nil)
+	return newGraphQLError("INTERNAL_SERVER_ERROR", "the server encounted a problem and could not process the request")
+}
+
+// The graphqlValidationError() function reports the problems found by a
+// validator
+func graphqlValidationError(v *validator.Validator) error {
+	err := newGraphQLError("FAILED_VALIDATION", "the input is not valid")
+	err.extensions["errors"] = v.Errors
+	return err
+}
+
+// The permissions of the user making a GraphQL request, read by the first
+// resolver that needs them
+type graphqlPermissions struct {
+	once        sync.Once
+	permissions data.Permissions
+	err         error
+}
+
+const graphqlPermissionsKey = contextKey("graphql_permissions")
+
+// The graphqlAuthorize() method does for a resolver what
+// requirePermission() does for a handler. It returns the user
+func (app *application) graphqlAuthorize(ctx context.Context, code string) (*data.User, error) {
+	user := contextUser(ctx)
+	if user.IsAnonymous() {
+		return nil, newGraphQLError("UNAUTHENTICATED", "you must be authenticated to access this resource")
+	}
+	if !user.Activated {
+		return nil, newGraphQLError("INACTIVE_ACCOUNT", "your user account must be activated to access this resource")
+	}
+	ok, err := app.graphqlHasPermission(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, newGraphQLError("FORBIDDEN", "your user account does not have the necessary permissions to access this resource")
+	}
+	return user, nil
+}
+
+// The graphqlHasPermission() method reports whether the user making a
+// GraphQL request has a permission
+func (app *application) graphqlHasPermission(ctx context.Context, code string) (bool, error) {
+	cached, ok := ctx.Value(graphqlPermissionsKey).(*graphqlPermissions)
+	if !ok {
+		panic("missing permissions value in request context")
+	}
+	cached.once.Do(func() {
+		cached.permissions, cached.err = app.models.Permissions.GetAllForUser(contextUser(ctx).ID)
+	})
+	if cached.err != nil {
+		return false, app.graphqlServerError(cached.err)
+	}
+	return cached.permissions.Include(code), nil
+}
+
+// The graphqlSeesUser() method reports whether the user making a GraphQL
+// request may see the private details of a user: only their own, unless
+// they are an administrator
+func (app *application) graphqlSeesUser(ctx context.Context, id int64) (bool, error) {
+	user := contextUser(ctx)
+	if user.IsAnonymous() || !user.Activated {
+		return false, nil
+	}
+	if user.ID == id {
+		return true, nil
+	}
+	return app.graphqlHasPermission(ctx, "toasts:admin")
+}
+
+// The graphqlID() function reads an ID argument
+func graphqlID(p graphql.ResolveParams, name string) (int64, bool) {
+	s, _ := p.Args[name].(string)
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 1 {
+		return 0, false
+	}
+	return id, true
+}
+
+// The JSON scalar holds the attributes, opening hours and event payloads
+// of toasts, which have structures of their own
+var graphqlJSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "A JSON value",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: graphqlLiteral,
+})
+
+// The graphqlLiteral() function turns a value written in a query into the
+// value JSON decoding would give
+func graphqlLiteral(value ast.Value) interface{} {
+	switch value := value.(type) {
+	case *ast.StringValue:
+		return value.Value
+	case *ast.BooleanValue:
+		return value.Value
+	case *ast.IntValue:
+		f, _ := strconv.ParseFloat(value.Value, 64)
+		return f
+	case *ast.FloatValue:
+		f, _ := strconv.ParseFloat(value.Value, 64)
+		return f
+	case *ast.EnumValue:
+		return value.Value
+	case *ast.ListValue:
+		list := make([]interface{}, len(value.Values))
+		for i, item := range value.Values {
+			list[i] = graphqlLiteral(item)
+		}
+		return list
+	case *ast.ObjectValue:
+		object := make(map[string]interface{}, len(value.Fields))
+		for _, field := range value.Fields {
+			object[field.Name.Value] = graphqlLiteral(field.Value)
+		}
+		return object
+	default:
+		return nil
+	}
+}
+
+// The newGraphQLSchema() method builds the GraphQL schema, whose resolvers
+// use the application's models
+func (app *application) newGraphQLSchema() (graphql.Schema, error) {
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"created_at": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"name":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"activated":  &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			// Only the user and administrators see the email address
+			"email": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user := p.Source.(*data.User)
+					ok, err := app.graphqlSeesUser(p.Context, user.ID)
+					if err != nil || !ok {
+						return nil, err
+					}
+					return user.Email, nil
+				},
+			},
+		},
+	})
+	eventType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ToastEvent",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"type":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"created_at": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"toast_id":   &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"toast":      &graphql.Field{Type: graphqlJSON},
+		},
+	})
+	toastType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Toast",
+		Fields: graphql.Fields{
+			"id":              &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"created_at":      &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"updated_at":      &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"name":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"level":           &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"contact":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"phone":           &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"phone_e164":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"email":           &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"website":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"address":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"mode":            &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"tags":            &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"attributes":      &graphql.Field{Type: graphqlJSON},
+			"hours":           &graphql.Field{Type: graphqlJSON},
+			"external_source": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"external_id":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"latitude":        &graphql.Field{Type: graphql.Float},
+			"longitude":       &graphql.Field{Type: graphql.Float},
+			"distance_km":     &graphql.Field{Type: graphql.Float},
+			"snippet":         &graphql.Field{Type: graphql.String},
+			"version":         &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			// The changes to the toast, newest first
+			"history": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(eventType))),
+				Args: graphql.FieldConfigArgument{
+					"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					limit, _ := p.Args["limit"].(int)
+					v := validator.New()
+					v.Check(limit > 0, "limit", "must be greater than zero")
+					v.Check(limit <= 100, "limit", "must be a maximum of 100")
+					if !v.Valid() {
+						return nil, graphqlValidationError(v)
+					}
+					events, err := app.models.Events.GetForToast(p.Source.(*data.Toast).ID, limit)
+					if err != nil {
+						return nil, app.graphqlServerError(err)
+					}
+					return events, nil
+				},
+			},
+			// The user who created the toast, when known
+			"creator": &graphql.Field{
+				Type: userType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					toast := p.Source.(*data.Toast)
+					if toast.CreatedBy == nil {
+						return nil, nil
+					}
+					user, err := app.models.Users.Get(*toast.CreatedBy)
+					if err != nil {
+						if errors.Is(err, data.ErrRecordNotFound) {
+							return nil, nil
+						}
+						return nil, app.graphqlServerError(err)
+					}
+					return user, nil
+				},
+			},
+		},
+	})
+	metadataType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Metadata",
+		Fields: graphql.Fields{
+			"current_page":  &graphql.Field{Type: graphql.Int},
+			"page_size":     &graphql.Field{Type: graphql.Int},
+			"first_page":    &graphql.Field{Type: graphql.Int},
+			"last_page":     &graphql.Field{Type: graphql.Int},
+			"total_records": &graphql.Field{Type: graphql.Int},
+			"next_cursor":   &graphql.Field{Type: graphql.String},
+			"prev_cursor":   &graphql.Field{Type: graphql.String},
+		},
+	})
+	toastPageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ToastPage",
+		Fields: graphql.Fields{
+			"items":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(toastType)))},
+			"metadata": &graphql.Field{Type: graphql.NewNonNull(metadataType)},
+		},
+	})
+	toastInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "ToastInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":            &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"level":           &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"contact":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"phone":           &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"email":           &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"website":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"address":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"mode":            &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"tags":            &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"attributes":      &graphql.InputObjectFieldConfig{Type: graphqlJSON},
+			"hours":           &graphql.InputObjectFieldConfig{Type: graphqlJSON},
+			"external_source": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"external_id":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+
+	// The toasts query takes the same parameters as "GET /v1/toasts"
+	toastsArgs := graphql.FieldConfigArgument{}
+	for _, name := range []string{"name", "level", "phone", "q", "name_fuzzy", "near", "open_at", "filter", "sort", "after", "before"} {
+		toastsArgs[name] = &graphql.ArgumentConfig{Type: graphql.String}
+	}
+	for _, name := range []string{"similarity", "radius_km"} {
+		toastsArgs[name] = &graphql.ArgumentConfig{Type: graphql.Float}
+	}
+	toastsArgs["mode"] = &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))}
+	toastsArgs["tag"] = &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))}
+	toastsArgs["open_now"] = &graphql.ArgumentConfig{Type: graphql.Boolean}
+	toastsArgs["page"] = &graphql.ArgumentConfig{Type: graphql.Int}
+	toastsArgs["page_size"] = &graphql.ArgumentConfig{Type: graphql.Int}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"toast": &graphql.Field{
+				Type: toastType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: app.resolveToast,
+			},
+			"toasts": &graphql.Field{
+				Type:    graphql.NewNonNull(toastPageType),
+				Args:    toastsArgs,
+				Resolve: app.resolveToasts,
+			},
+			"me": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user := contextUser(p.Context)
+					if user.IsAnonymous() {
+						return nil, newGraphQLError("UNAUTHENTICATED", "you must be authenticated to access this resource")
+					}
+					return user, nil
+				},
+			},
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: app.resolveUser,
+			},
+		},
+	})
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createToast": &graphql.Field{
+				Type: toastType,
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(toastInputType)},
+					"force": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: app.resolveCreateToast,
+			},
+			"updateToast": &graphql.Field{
+				Type: toastType,
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"version": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"input":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(toastInputType)},
+				},
+				Resolve: app.resolveUpdateToast,
+			},
+			"deleteToast": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"version": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: app.resolveDeleteToast,
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// The resolveToast() method resolves the toast query. A toast that doesn't
+// exist is null
+func (app *application) resolveToast(p graphql.ResolveParams) (interface{}, error) {
+	_, err := app.graphqlAuthorize(p.Context, "toasts:read")
+	if err != nil {
+		return nil, err
+	}
+	id, ok := graphqlID(p, "id")
+	if !ok {
+		return nil, nil
+	}
+	toast, err := app.models.Toasts.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, app.graphqlServerError(err)
+	}
+	return toast, nil
+}
+
+// The resolveToasts() method resolves the toasts query. Its arguments are
+// read as the query string of "GET /v1/toasts" would be
+func (app *application) resolveToasts(p graphql.ResolveParams) (interface{}, error) {
+	_, err := app.graphqlAuthorize(p.Context, "toasts:read")
+	if err != nil {
+		return nil, err
+	}
+	qs := make(url.Values)
+	for name, value := range p.Args {
+		switch value := value.(type) {
+		case []interface{}:
+			items := make([]string, len(value))
+			for i := range value {
+				items[i] = fmt.Sprint(value[i])
+			}
+			qs.Set(name, strings.Join(items, ","))
+		default:
+			qs.Set(name, fmt.Sprint(value))
+		}
+	}
+	v := validator.New()
+	search := app.readToastSearch(qs, v)
+	filters, err := app.readToastFilters(qs, search, v)
+	if err != nil {
+		return nil, app.graphqlServerError(err)
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+	toasts, metadata, err := app.models.Toasts.GetAll(search, filters)
+	if err != nil {
+		return nil, app.graphqlServerError(err)
+	}
+	return map[string]interface{}{"items": toasts, "metadata": metadata}, nil
+}
+
+// The resolveUser() method resolves the user query. Users can only look
+// themselves up, unless they are an administrator
+func (app *application) resolveUser(p graphql.ResolveParams) (interface{}, error) {
+	user := contextUser(p.Context)
+	if user.IsAnonymous() {
+		return nil, newGraphQLError("UNAUTHENTICATED", "you must be authenticated to access this resource")
+	}
+	id, ok := graphqlID(p, "id")
+	if !ok {
+		return nil, nil
+	}
+	if id == user.ID {
+		return user, nil
+	}
+	_, err := app.graphqlAuthorize(p.Context, "toasts:admin")
+	if err != nil {
+		return nil, err
+	}
+	other, err := app.models.Users.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, app.graphqlServerError(err)
+	}
+	return other, nil
+}
+
+// The resolveCreateToast() method resolves the createToast mutation like
+// "POST /v1/toasts"
+func (app *application) resolveCreateToast(p graphql.ResolveParams) (interface{}, error) {
+	user, err := app.graphqlAuthorize(p.Context, "toasts:write")
+	if err != nil {
+		return nil, err
+	}
+	toast := &data.Toast{CreatedBy: &user.ID}
+	v := validator.New()
+	applyToastInput(toast, p.Args["input"].(map[string]interface{}), v)
+	if !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+	force, _ := p.Args["force"].(bool)
+	duplicates, err := app.createToast(toast, force, v)
+	if err != nil {
+		switch {
+		case errors.Is(err, errFailedValidation):
+			return nil, graphqlValidationError(v)
+		case errors.Is(err, errDuplicateToast):
+			err := newGraphQLError("DUPLICATE", "the toast looks like an existing toast, resend with force set to create it anyway")
+			err.extensions["duplicates"] = duplicates
+			return nil, err
+		default:
+			return nil, app.graphqlServerError(err)
+		}
+	}
+	return toast, nil
+}
+
+// The resolveUpdateToast() method resolves the updateToast mutation like
+// "PATCH /v1/toasts/:id". The version must be the one the client has seen
+func (app *application) resolveUpdateToast(p graphql.ResolveParams) (interface{}, error) {
+	_, err := app.graphqlAuthorize(p.Context, "toasts:write")
+	if err != nil {
+		return nil, err
+	}
+	toast, err := app.graphqlToastVersion(p)
+	if err != nil || toast == nil {
+		return nil, err
+	}
+	v := validator.New()
+	applyToastInput(toast, p.Args["input"].(map[string]interface{}), v)
+	if !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+	err = app.updateToast(toast, v)
+	if err != nil {
+		switch {
+		case errors.Is(err, errFailedValidation):
+			return nil, graphqlValidationError(v)
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, newGraphQLError("EDIT_CONFLICT", "the record has been modified since you last retrieved it, please fetch it again")
+		default:
+			return nil, app.graphqlServerError(err)
+		}
+	}
+	return toast, nil
+}
+
+// The resolveDeleteToast() method resolves the deleteToast mutation like
+// "DELETE /v1/toasts/:id". It returns false when there is no such toast
+func (app *application) resolveDeleteToast(p graphql.ResolveParams) (interface{}, error) {
+	_, err := app.graphqlAuthorize(p.Context, "toasts:write")
+	if err != nil {
+		return nil, err
+	}
+	toast, err := app.graphqlToastVersion(p)
+	if err != nil || toast == nil {
+		return false, err
+	}
+	err = app.deleteToast(toast)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, newGraphQLError("EDIT_CONFLICT", "the record has been modified since you last retrieved it, please fetch it again")
+		default:
+			return nil, app.graphqlServerError(err)
+		}
+	}
+	return true, nil
+}
+
+// The graphqlToastVersion() method fetches the toast a mutation names and
+// checks it is still at the version the client gave. A toast that doesn't
+// exist is nil
+func (app *application) graphqlToastVersion(p graphql.ResolveParams) (*data.Toast, error) {
+	id, ok := graphqlID(p, "id")
+	if !ok {
+		return nil, nil
+	}
+	toast, err := app.models.Toasts.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, app.graphqlServerError(err)
+	}
+	if version, _ := p.Args["version"].(int); int32(version) != toast.Version {
+		return nil, newGraphQLError("EDIT_CONFLICT", "the record has been modified since you last retrieved it, please fetch it again")
+	}
+	return toast, nil
+}
+
+// The applyToastInput() function copies the fields given in a ToastInput
+// to a toast. Fields left out are not changed and null empties a field.
+// Attributes and hours that can't be read are added to v
+func applyToastInput(toast *data.Toast, input map[string]interface{}, v *validator.Validator) {
+	text := func(name string, dst *string) {
+		if value, ok := input[name]; ok {
+			*dst, _ = value.(string)
+		}
+	}
+	list := func(name string, dst *[]string) {
+		value, ok := input[name]
+		if !ok {
+			return
+		}
+		items, _ := value.([]interface{})
+		*dst = make([]string, len(items))
+		for i := range items {
+			(*dst)[i], _ = items[i].(string)
+		}
+	}
+	// The structured fields are decoded as they are in the JSON API
+	structured := func(name string, dst interface{}) {
+		value, ok := input[name]
+		if !ok {
+			return
+		}
+		js, err := json.Marshal(value)
+		if err == nil {
+			err = json.Unmarshal(js, dst)
+		}
+		if err != nil {
+			v.AddError(name, "must be valid "+name)
+		}
+	}
+	text("name", &toast.Name)
+	text("level", &toast.Level)
+	text("contact", &toast.Contact)
+	text("phone", &toast.Phone)
+	text("email", &toast.Email)
+	text("website", &toast.Website)
+	text("address", &toast.Address)
+	list("mode", &toast.Mode)
+	list("tags", &toast.Tags)
+	if _, ok := input["attributes"]; ok {
+		toast.Attributes = nil
+		structured("attributes", &toast.Attributes)
+	}
+	if _, ok := input["hours"]; ok {
+		toast.Hours = nil
+		structured("hours", &toast.Hours)
+	}
+	text("external_source", &toast.ExternalSource)
+	text("external_id", &toast.ExternalID)
+}
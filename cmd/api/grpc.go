@@ -0,0 +1,522 @@
+// Filename: cmd/api/grpc.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/toasterpb"
+	"toaster.jalen.net/internals/validator"
+)
+
+// The permission each gRPC method needs. An empty code lets anyone call
+// the method, and methods that aren't listed are refused
+var grpcPermissions = map[string]string{
+	"/toaster.v1.ToastService/GetToast":                  "toasts:read",
+	"/toaster.v1.ToastService/ListToasts":                "toasts:read",
+	"/toaster.v1.ToastService/CreateToast":               "toasts:write",
+	"/toaster.v1.ToastService/UpdateToast":               "toasts:write",
+	"/toaster.v1.ToastService/DeleteToast":               "toasts:write",
+	"/toaster.v1.TokenService/CreateAuthenticationToken": "",
+}
+
+// The newGRPCServer() method returns the gRPC server. Its interceptors do
+// what recoverPanic(), rateLimit(), authenticate() and requirePermission()
+// do for the JSON API
+func (app *application) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		app.grpcRecoverPanic,
+		app.grpcRateLimit(),
+		app.grpcAuthenticate,
+		app.grpcRequirePermission,
+	))
+	toasterpb.RegisterToastServiceServer(srv, &toastServer{app: app})
+	toasterpb.RegisterTokenServiceServer(srv, &tokenServer{app: app})
+	return srv
+}
+
+// The grpcRecoverPanic() method turns a panic in a method into an error
+func (app *application) grpcRecoverPanic(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			resp, err = nil, app.grpcServerError(fmt.Errorf("%s", r))
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// The grpcRateLimit() method returns an interceptor that limits how often
+// each peer, named by its IP address, calls methods
+func (app *application) grpcRateLimit() grpc.UnaryServerInterceptor {
+	limiter := app.newClientLimiter()
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !app.config.limiter.enabled {
+			return handler(ctx, req)
+		}
+		p, ok := peer.FromContext(ctx)
+		if !ok {
+			return nil, app.grpcServerError(errors.New("no peer in the context"))
+		}
+		ip, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return nil, app.grpcServerError(err)
+		}
+		if !limiter.allow(ip) {
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// The grpcAuthenticate() method adds the user whose token is in the
+// "authorization" metadata to the context. Without a token the user is
+// anonymous
+func (app *application) grpcAuthenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return handler(context.WithValue(ctx, userContextKey, data.AnonymousUser), req)
+	}
+	invalid := status.Error(codes.Unauthenticated, "invalid or missing authentication token")
+	headerParts := strings.Split(values[0], " ")
+	if len(values) != 1 || len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return nil, invalid
+	}
+	token := headerParts[1]
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		return nil, invalid
+	}
+	user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, invalid
+		default:
+			return nil, app.grpcServerError(err)
+		}
+	}
+	return handler(context.WithValue(ctx, userContextKey, user), req)
+}
+
+// The grpcRequirePermission() method checks the user has the permission
+// the method needs
+func (app *application) grpcRequirePermission(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	code, ok := grpcPermissions[info.FullMethod]
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "your user account does not have the necessary permissions to access this resource")
+	}
+	if code == "" {
+		return handler(ctx, req)
+	}
+	user := contextUser(ctx)
+	if user.IsAnonymous() {
+		return nil, status.Error(codes.Unauthenticated, "you must be authenticated to access this resource")
+	}
+	if !user.Activated {
+		return nil, status.Error(codes.PermissionDenied, "your user account must be activated to access this resource")
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, app.grpcServerError(err)
+	}
+	if !permissions.Include(code) {
+		return nil, status.Error(codes.PermissionDenied, "your user account does not have the necessary permissions to access this resource")
+	}
+	return handler(ctx, req)
+}
+
+// The grpcServerError() method logs an unexpected error and returns the
+// error the client is told about instead
+func (app *application) grpcServerError(err error) error {
+	app.logger.PrintError(err, nil)
+	return status.Error(codes.Internal, "the server encounted a problem and could not process the request")
+}
+
+// The grpcValidationError() function reports the problems found by a
+// validator as the fields of a bad request
+func grpcValidationError(v *validator.Validator) error {
+	st := status.New(codes.InvalidArgument, "the input is not valid")
+	details := &errdetails.BadRequest{}
+	for field, message := range v.Errors {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: message,
+		})
+	}
+	sort.Slice(details.FieldViolations, func(i, j int) bool {
+		return details.FieldViolations[i].Field < details.FieldViolations[j].Field
+	})
+	withDetails, err := st.WithDetails(details)
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// Errors the toast methods share
+var (
+	grpcNotFound     = status.Error(codes.NotFound, "the requested resource could not be found")
+	grpcEditConflict = status.Error(codes.Aborted, "the record has been modified since you last retrieved it, please fetch it again")
+)
+
+// The toastServer implements ToastService
+type toastServer struct {
+	toasterpb.UnimplementedToastServiceServer
+	app *application
+}
+
+// GetToast() returns a toast like "GET /v1/toasts/:id"
+func (s *toastServer) GetToast(ctx context.Context, req *toasterpb.GetToastRequest) (*toasterpb.Toast, error) {
+	toast, err := s.app.models.Toasts.Get(req.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, grpcNotFound
+		default:
+			return nil, s.app.grpcServerError(err)
+		}
+	}
+	return s.app.toastProto(toast)
+}
+
+// ListToasts() returns a page of toasts like "GET /v1/toasts". The request
+// is read as that endpoint's query string would be
+func (s *toastServer) ListToasts(ctx context.Context, req *toasterpb.ListToastsRequest) (*toasterpb.ListToastsResponse, error) {
+	qs := make(url.Values)
+	set := func(name, value string) {
+		if value != "" {
+			qs.Set(name, value)
+		}
+	}
+	set("name", req.Name)
+	set("level", req.Level)
+	set("mode", strings.Join(req.Mode, ","))
+	set("phone", req.Phone)
+	set("q", req.Q)
+	set("name_fuzzy", req.NameFuzzy)
+	if req.Similarity != nil {
+		set("similarity", strconv.FormatFloat(*req.Similarity, 'f', -1, 64))
+	}
+	set("near", req.Near)
+	if req.RadiusKm != nil {
+		set("radius_km", strconv.FormatFloat(*req.RadiusKm, 'f', -1, 64))
+	}
+	if req.OpenNow {
+		set("open_now", "true")
+	}
+	if req.OpenAt != nil {
+		set("open_at", req.OpenAt.AsTime().Format(time.RFC3339))
+	}
+	set("filter", req.Filter)
+	set("sort", req.Sort)
+	if req.Page != 0 {
+		set("page", strconv.Itoa(int(req.Page)))
+	}
+	if req.PageSize != 0 {
+		set("page_size", strconv.Itoa(int(req.PageSize)))
+	}
+	set("after", req.After)
+	set("before", req.Before)
+
+	v := validator.New()
+	search := s.app.readToastSearch(qs, v)
+	filters, err := s.app.readToastFilters(qs, search, v)
+	if err != nil {
+		return nil, s.app.grpcServerError(err)
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, grpcValidationError(v)
+	}
+	toasts, metadata, err := s.app.models.Toasts.GetAll(search, filters)
+	if err != nil {
+		return nil, s.app.grpcServerError(err)
+	}
+	resp := &toasterpb.ListToastsResponse{
+		Toasts: make([]*toasterpb.Toast, len(toasts)),
+		Metadata: &toasterpb.Metadata{
+			CurrentPage:  int32(metadata.CurrentPage),
+			PageSize:     int32(metadata.PageSize),
+			FirstPage:    int32(metadata.FirstPage),
+			LastPage:     int32(metadata.LastPage),
+			TotalRecords: int32(metadata.TotalRecords),
+			NextCursor:   metadata.NextCursor,
+			PrevCursor:   metadata.PrevCursor,
+		},
+	}
+	for i := range toasts {
+		resp.Toasts[i], err = s.app.toastProto(toasts[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// CreateToast() creates a toast like "POST /v1/toasts"
+func (s *toastServer) CreateToast(ctx context.Context, req *toasterpb.CreateToastRequest) (*toasterpb.Toast, error) {
+	user := contextUser(ctx)
+	toast := &data.Toast{CreatedBy: &user.ID}
+	v := validator.New()
+	applyToastProto(toast, req.Toast, nil, v)
+	if !v.Valid() {
+		return nil, grpcValidationError(v)
+	}
+	duplicates, err := s.app.createToast(toast, req.Force, v)
+	if err != nil {
+		switch {
+		case errors.Is(err, errFailedValidation):
+			return nil, grpcValidationError(v)
+		case errors.Is(err, errDuplicateToast):
+			ids := make([]string, len(duplicates))
+			for i, duplicate := range duplicates {
+				ids[i] = strconv.FormatInt(duplicate.ID, 10)
+			}
+			message := fmt.Sprintf("the toast looks like existing toasts (%s), resend with force to create it anyway", strings.Join(ids, ", "))
+			return nil, status.Error(codes.AlreadyExists, message)
+		default:
+			return nil, s.app.grpcServerError(err)
+		}
+	}
+	return s.app.toastProto(toast)
+}
+
+// UpdateToast() changes the fields of a toast named by the update mask, or
+// replaces all of them, like "PATCH /v1/toasts/:id" and "PUT /v1/toasts/:id"
+func (s *toastServer) UpdateToast(ctx context.Context, req *toasterpb.UpdateToastRequest) (*toasterpb.Toast, error) {
+	toast, err := s.app.grpcToastVersion(req.Id, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	v := validator.New()
+	applyToastProto(toast, req.Toast, req.UpdateMask.GetPaths(), v)
+	if !v.Valid() {
+		return nil, grpcValidationError(v)
+	}
+	err = s.app.updateToast(toast, v)
+	if err != nil {
+		switch {
+		case errors.Is(err, errFailedValidation):
+			return nil, grpcValidationError(v)
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, grpcEditConflict
+		default:
+			return nil, s.app.grpcServerError(err)
+		}
+	}
+	return s.app.toastProto(toast)
+}
+
+// DeleteToast() removes a toast like "DELETE /v1/toasts/:id"
+func (s *toastServer) DeleteToast(ctx context.Context, req *toasterpb.DeleteToastRequest) (*toasterpb.DeleteToastResponse, error) {
+	toast, err := s.app.grpcToastVersion(req.Id, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	err = s.app.deleteToast(toast)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil, grpcEditConflict
+		default:
+			return nil, s.app.grpcServerError(err)
+		}
+	}
+	return &toasterpb.DeleteToastResponse{}, nil
+}
+
+// The grpcToastVersion() method fetches the toast a method names and checks
+// it is still at the version the client gave
+func (app *application) grpcToastVersion(id int64, version int32) (*data.Toast, error) {
+	toast, err := app.models.Toasts.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, grpcNotFound
+		default:
+			return nil, app.grpcServerError(err)
+		}
+	}
+	if toast.Version != version {
+		return nil, grpcEditConflict
+	}
+	return toast, nil
+}
+
+// The tokenServer implements TokenService
+type tokenServer struct {
+	toasterpb.UnimplementedTokenServiceServer
+	app *application
+}
+
+// CreateAuthenticationToken() returns a token for the user's email and
+// password like "POST /v1/tokens/authentication"
+func (s *tokenServer) CreateAuthenticationToken(ctx context.Context, req *toasterpb.CreateAuthenticationTokenRequest) (*toasterpb.AuthenticationToken, error) {
+	v := validator.New()
+	data.ValidateEmail(v, req.Email)
+	data.ValidatePasswordPlaintext(v, req.Password)
+	if !v.Valid() {
+		return nil, grpcValidationError(v)
+	}
+	invalid := status.Error(codes.Unauthenticated, "invalid authentication credentials")
+	user, err := s.app.models.Users.GetByEmail(req.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, invalid
+		default:
+			return nil, s.app.grpcServerError(err)
+		}
+	}
+	match, err := user.Password.Matches(req.Password)
+	if err != nil {
+		return nil, s.app.grpcServerError(err)
+	}
+	if !match {
+		return nil, invalid
+	}
+	token, err := s.app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		return nil, s.app.grpcServerError(err)
+	}
+	return &toasterpb.AuthenticationToken{
+		Token:  token.Plaintext,
+		Expiry: timestamppb.New(token.Expiry),
+	}, nil
+}
+
+// The toastProto() method converts a toast to its gRPC message
+func (app *application) toastProto(toast *data.Toast) (*toasterpb.Toast, error) {
+	attributes, err := jsonStruct(toast.Attributes)
+	if err != nil {
+		return nil, app.grpcServerError(err)
+	}
+	var hours *structpb.Struct
+	if toast.Hours != nil {
+		hours, err = jsonStruct(toast.Hours)
+		if err != nil {
+			return nil, app.grpcServerError(err)
+		}
+	}
+	return &toasterpb.Toast{
+		Id:             toast.ID,
+		CreatedAt:      timestamppb.New(toast.CreatedAt),
+		UpdatedAt:      timestamppb.New(toast.UpdatedAt),
+		Name:           toast.Name,
+		Level:          toast.Level,
+		Contact:        toast.Contact,
+		Phone:          toast.Phone,
+		PhoneE164:      toast.PhoneE164,
+		Email:          toast.Email,
+		Website:        toast.Website,
+		Address:        toast.Address,
+		Mode:           toast.Mode,
+		Tags:           toast.Tags,
+		Attributes:     attributes,
+		Hours:          hours,
+		ExternalSource: toast.ExternalSource,
+		ExternalId:     toast.ExternalID,
+		Latitude:       toast.Latitude,
+		Longitude:      toast.Longitude,
+		CreatedBy:      toast.CreatedBy,
+		Version:        toast.Version,
+	}, nil
+}
+
+// The jsonStruct() function converts a value to a Struct through its JSON
+// form
+func jsonStruct(value interface{}) (*structpb.Struct, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(js, &fields)
+	if err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, nil
+	}
+	return structpb.NewStruct(fields)
+}
+
+// The applyToastProto() function copies the fields named by paths from a
+// ToastInput to a toast. No paths means every field. Unknown paths, and
+// attributes and hours that can't be read, are added to v
+func applyToastProto(toast *data.Toast, input *toasterpb.ToastInput, paths []string, v *validator.Validator) {
+	if input == nil {
+		input = &toasterpb.ToastInput{}
+	}
+	if len(paths) == 0 {
+		paths = []string{
+			"name", "level", "contact", "phone", "email", "website", "address", "mode",
+			"tags", "attributes", "hours", "external_source", "external_id",
+		}
+	}
+	// The structured fields are decoded as they are in the JSON API
+	structured := func(name string, value *structpb.Struct, dst interface{}) {
+		if value == nil {
+			return
+		}
+		js, err := value.MarshalJSON()
+		if err == nil {
+			err = json.Unmarshal(js, dst)
+		}
+		if err != nil {
+			v.AddError(name, "must be valid "+name)
+		}
+	}
+	for _, path := range paths {
+		switch path {
+		case "name":
+			toast.Name = input.Name
+		case "level":
+			toast.Level = input.Level
+		case "contact":
+			toast.Contact = input.Contact
+		case "phone":
+			toast.Phone = input.Phone
+		case "email":
+			toast.Email = input.Email
+		case "website":
+			toast.Website = input.Website
+		case "address":
+			toast.Address = input.Address
+		case "mode":
+			toast.Mode = input.Mode
+		case "tags":
+			toast.Tags = input.Tags
+		case "attributes":
+			toast.Attributes = nil
+			structured("attributes", input.Attributes, &toast.Attributes)
+		case "hours":
+			toast.Hours = nil
+			structured("hours", input.Hours, &toast.Hours)
+		case "external_source":
+			toast.ExternalSource = input.ExternalSource
+		case "external_id":
+			toast.ExternalID = input.ExternalId
+		default:
+			v.AddError("update_mask", fmt.Sprintf("%q is not a field of a toast", path))
+		}
+	}
+}
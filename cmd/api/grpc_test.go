@@ -0,0 +1,48 @@
+// Filename: cmd/api/grpc_test.go
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCRateLimit(t *testing.T) {
+	app := &application{}
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 0.001
+	app.config.limiter.burst = 2
+	interceptor := app.grpcRateLimit()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/toaster.v1.ToastService/GetToast"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	call := func(ip string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000},
+		})
+		_, err := interceptor(ctx, nil, info, handler)
+		return err
+	}
+
+	// A peer gets its burst, then has to wait
+	for i := 0; i < 2; i++ {
+		if err := call("203.0.113.7"); err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+	}
+	if err := call("203.0.113.7"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("call 3 returned %v, want ResourceExhausted", err)
+	}
+	// Other peers have their own budget
+	if err := call("203.0.113.8"); err != nil {
+		t.Errorf("another peer: %v", err)
+	}
+}
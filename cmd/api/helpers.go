@@ -3,16 +3,21 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/julienschmidt/httprouter"
+	"toaster.jalen.net/internals/phone"
 	"toaster.jalen.net/internals/validator"
 )
 
@@ -30,6 +35,61 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// The readContactIDParam() method returns the id of a toast's contact
+func (app *application) readContactIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("contact_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid contact_id parameter")
+	}
+	return id, nil
+}
+
+// The readAttachmentIDParam() method returns the id of a toast's attachment
+func (app *application) readAttachmentIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("attachment_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid attachment_id parameter")
+	}
+	return id, nil
+}
+
+// The readDeliveryIDParam() method returns the id of a webhook's delivery
+func (app *application) readDeliveryIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("delivery_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid delivery_id parameter")
+	}
+	return id, nil
+}
+
+// The readExternalIDParams() method returns the source and external id
+// parameters of a toast's external reference
+func (app *application) readExternalIDParams(r *http.Request) (string, string) {
+	params := httprouter.ParamsFromContext(r.Context())
+	return params.ByName("source"), params.ByName("external_id")
+}
+
+// The readTermParam() method returns the vocabulary term parameter
+func (app *application) readTermParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return params.ByName("term")
+}
+
+// The readLocaleParam() method returns the locale parameter in lower case
+func (app *application) readLocaleParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return strings.ToLower(params.ByName("locale"))
+}
+
+// The readNameParam() method returns the name parameter
+func (app *application) readNameParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return params.ByName("name")
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	// Convert our map into a JSON object
 	js, err := json.MarshalIndent(data, "", "\t")
@@ -106,6 +166,99 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// Media types accepted for PATCH requests
+const (
+	mediaTypeJSON       = "application/json"
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+// The readMediaType() method returns the media type of the request body
+// without any parameters. A missing Content-Type is treated as JSON
+func (app *application) readMediaType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return mediaTypeJSON
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// The readPatch() method applies a JSON Merge Patch (RFC 7396) or a JSON
+// Patch (RFC 6902) request body to the JSON form of original and decodes
+// the patched document into dst
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, mediaType string, original interface{}, dst interface{}) error {
+	// Limit the size of the request body to 1 MB 2^20
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		}
+		return err
+	}
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return errors.New("body must not be empty")
+	}
+	// Convert the original value to the document we are patching
+	document, err := json.Marshal(original)
+	if err != nil {
+		return err
+	}
+	// Apply the patch
+	var patched []byte
+	switch mediaType {
+	case mediaTypeMergePatch:
+		patched, err = jsonpatch.MergePatch(document, patch)
+		if err != nil {
+			return errors.New("body contains an invalid merge patch")
+		}
+	case mediaTypeJSONPatch:
+		operations, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return errors.New("body contains an invalid JSON patch")
+		}
+		patched, err = operations.Apply(document)
+		if err != nil {
+			return fmt.Errorf("unable to apply JSON patch: %s", err)
+		}
+	default:
+		panic("unsupported patch media type: " + mediaType)
+	}
+	// Decode the patched document with the same checks as a JSON body
+	r.Body = io.NopCloser(bytes.NewReader(patched))
+	return app.readJSON(w, r, dst)
+}
+
+// The selectFields() method converts a value to a map holding only the
+// requested JSON fields. Without fields the value is returned unchanged
+func (app *application) selectFields(value interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return value, nil
+	}
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	err = json.Unmarshal(js, &all)
+	if err != nil {
+		return nil, err
+	}
+	// Fields left out by omitempty stay left out
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if raw, ok := all[field]; ok {
+			selected[field] = raw
+		}
+	}
+	return selected, nil
+}
+
 // The readString() method returns a string value from the query parameter
 // string or returns a default value if no matching key is found
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -147,6 +300,163 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return intValue
 }
 
+// The etag() function formats a record version as a strong entity tag
+func etag(version int32) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// The translatedETag() function formats the entity tag of a toast
+// translated into locale. It differs from the toast's own tag, so If-Match
+// never takes it for the stored toast, which is what gets edited
+func translatedETag(version int32, locale string) string {
+	return fmt.Sprintf(`"%d-%s"`, version, locale)
+}
+
+// The etagVersion() function reads the record version of a single strong
+// entity tag made by etag()
+func etagVersion(header string) (int32, bool) {
+	header = strings.TrimSpace(header)
+	if len(header) < 3 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 32)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return int32(version), true
+}
+
+// The etagMatches() function reports whether an If-Match or If-None-Match
+// header value matches the entity tag. A "*" matches any tag. A weak
+// comparison ignores the W/ prefix, as If-None-Match requires
+func etagMatches(header string, tag string, weak bool) bool {
+	// The header may hold a comma separated list of tags
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" {
+			return true
+		}
+		if weak {
+			value = strings.TrimPrefix(value, "W/")
+		}
+		if value == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// The readFloat() method converts a string value from the query string to a
+// float value. If the value cannot be converted then a validation error is
+// added to the validation errors map
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	// Get the value
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	// Perform the conversion to a float
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+	return floatValue
+}
+
+// The readBool() method converts a string value from the query string to a
+// boolean value. If the value cannot be converted then a validation error
+// is added to the validation errors map
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	// Get the value
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	// Perform the conversion to a boolean
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return defaultValue
+	}
+	return boolValue
+}
+
+// The readLatLng() method converts a "lat,lng" pair from the query string
+// to a latitude and longitude. Invalid coordinates are added to the
+// validation errors map
+func (app *application) readLatLng(values []string, key string, v *validator.Validator) (float64, float64) {
+	if len(values) != 2 {
+		v.AddError(key, "must be a latitude and longitude separated by a comma")
+		return 0, 0
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(values[0]), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		v.AddError(key, "must have a latitude between -90 and 90")
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(values[1]), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		v.AddError(key, "must have a longitude between -180 and 180")
+	}
+	return latitude, longitude
+}
+
+// The readPhone() method converts a phone number from the query string to
+// its E.164 form. A number that can't be read is added to the validation
+// errors map
+func (app *application) readPhone(value string, key string, v *validator.Validator) string {
+	number, err := phone.Parse(value, app.config.phone.region)
+	if err != nil {
+		v.AddError(key, "must be a valid phone number")
+		return ""
+	}
+	return number.E164
+}
+
+// The readLocale() method returns the supported locale the client prefers
+// according to its Accept-Language header. A language matches a locale
+// for a region of it and the other way around (es-MX and es). Without a
+// match it is the first supported locale
+func (app *application) readLocale(r *http.Request) string {
+	supported := app.config.locales.supported
+	type preference struct {
+		tag string
+		q   float64
+	}
+	var preferences []preference
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			var err error
+			q, err = strconv.ParseFloat(params[2:], 64)
+			if err != nil {
+				continue
+			}
+		}
+		if tag == "" || q <= 0 {
+			continue
+		}
+		preferences = append(preferences, preference{tag, q})
+	}
+	// The most preferred first, in the order given when equally preferred
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].q > preferences[j].q
+	})
+	for _, p := range preferences {
+		if p.tag == "*" {
+			return supported[0]
+		}
+		for _, locale := range supported {
+			if p.tag == locale || strings.HasPrefix(p.tag, locale+"-") || strings.HasPrefix(locale, p.tag+"-") {
+				return locale
+			}
+		}
+	}
+	return supported[0]
+}
+
 // Background accepts a function as its parameter
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter
@@ -0,0 +1,56 @@
+// Filename: cmd/api/helpers_test.go
+
+package main
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		tag    string
+		weak   bool
+		want   bool
+	}{
+		{"same version", `"3"`, etag(3), false, true},
+		{"other version", `"2"`, etag(3), false, false},
+		{"any version", `*`, etag(3), false, true},
+		{"one of a list", `"2", "3"`, etag(3), false, true},
+		{"weak for If-Match", `W/"3"`, etag(3), false, false},
+		{"weak for If-None-Match", `W/"3"`, etag(3), true, true},
+		// A translated toast can't be edited as if it were the stored one
+		{"translation for If-Match", translatedETag(3, "es"), etag(3), false, false},
+		{"translation for If-None-Match", translatedETag(3, "es"), translatedETag(3, "es"), true, true},
+		{"other translation", translatedETag(3, "fr"), translatedETag(3, "es"), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, tt.tag, tt.weak); got != tt.want {
+				t.Errorf("etagMatches(%q, %q, %t) = %t, want %t", tt.header, tt.tag, tt.weak, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestETagVersion(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int32
+		ok     bool
+	}{
+		{`"3"`, 3, true},
+		{` "12" `, 12, true},
+		{`W/"3"`, 0, false},
+		{`"3", "4"`, 0, false},
+		{translatedETag(3, "es"), 0, false},
+		{`"0"`, 0, false},
+		{`3`, 0, false},
+		{`"99999999999"`, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := etagVersion(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("etagVersion(%q) = %d, %t, want %d, %t", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
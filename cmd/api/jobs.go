@@ -0,0 +1,223 @@
+// Filename: cmd/api/jobs.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/phone"
+)
+
+// The backfillPhones() method works out the E.164 form of the phone numbers
+// of toasts that have none, such as the rows that existed before migration
+// 000012 added it. It runs once after that migration, when the server is
+// started with -phone-backfill. Numbers that can't be read are logged and
+// left for a person to correct; the next edit of the toast has to fix them
+func (app *application) backfillPhones() {
+	var afterID int64
+	count := 0
+	for {
+		toasts, err := app.models.Toasts.GetUnnormalizedPhones(afterID, 100)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+		if len(toasts) == 0 {
+			break
+		}
+		for _, toast := range toasts {
+			afterID = toast.ID
+			number, err := phone.Parse(toast.Phone, app.config.phone.region)
+			if err != nil {
+				app.logger.PrintInfo("unreadable phone number", map[string]string{
+					"toast_id": strconv.FormatInt(toast.ID, 10),
+					"phone":    toast.Phone,
+				})
+				continue
+			}
+			err = app.models.Toasts.SetPhoneE164(toast.ID, toast.Phone, number.E164)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				return
+			}
+			count++
+		}
+	}
+	if count > 0 {
+		app.logger.PrintInfo("phone numbers backfilled", map[string]string{"count": strconv.Itoa(count)})
+	}
+}
+
+// The backfillEvents() method records a creation event for the toasts that
+// were stored before changes to toasts were recorded, so a client syncing
+// from the start of the change feed gets every toast
+func (app *application) backfillEvents() {
+	count := 0
+	for {
+		n, err := app.models.Events.RecordExisting(100)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+		if n == 0 {
+			break
+		}
+		count += n
+		select {
+		case <-app.shutdown:
+			return
+		default:
+		}
+	}
+	if count > 0 {
+		app.logger.PrintInfo("toast events recorded", map[string]string{"count": strconv.Itoa(count)})
+	}
+}
+
+// Settings for sending webhooks. A delivery is tried up to
+// webhookMaxAttempts times, waiting twice as long after each failure
+const (
+	webhookInterval    = 5 * time.Second
+	webhookBatch       = 20
+	webhookTimeout     = 10 * time.Second
+	webhookLease       = time.Minute
+	webhookMaxAttempts = 10
+	webhookBackoff     = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+)
+
+// The client webhooks are posted with. Redirects are not followed, so a
+// delivery only succeeds at the URL that was registered
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// The deliverWebhooks() method sends the webhook deliveries as they become
+// due, until the server shuts down
+func (app *application) deliverWebhooks() {
+	ticker := time.NewTicker(webhookInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-app.shutdown:
+			return
+		case <-ticker.C:
+		}
+		// Keep going while there is a backlog
+		for {
+			deliveries, err := app.models.Webhooks.ClaimDeliveries(webhookBatch, webhookLease)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				break
+			}
+			if len(deliveries) == 0 {
+				break
+			}
+			var wg sync.WaitGroup
+			for _, delivery := range deliveries {
+				wg.Add(1)
+				go func(delivery *data.Delivery) {
+					defer wg.Done()
+					app.sendDelivery(delivery)
+				}(delivery)
+			}
+			wg.Wait()
+			select {
+			case <-app.shutdown:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// The sendDelivery() method posts a delivery's event to its webhook and
+// records the result. The body is signed with the webhook's secret: the
+// X-Toaster-Signature header is "t=<unix time>,v1=<hex HMAC-SHA256 of the
+// time, a dot and the body>"
+func (app *application) sendDelivery(delivery *data.Delivery) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(delivery.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	signature := "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+
+	delivery.LastStatusCode, delivery.LastError = 0, ""
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Toaster-Webhooks/"+version)
+		req.Header.Set("X-Toaster-Event", delivery.Event.Type)
+		req.Header.Set("X-Toaster-Delivery", strconv.FormatInt(delivery.ID, 10))
+		req.Header.Set("X-Toaster-Signature", signature)
+		var resp *http.Response
+		resp, err = webhookClient.Do(req)
+		if err == nil {
+			// Read some of the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 65_536))
+			resp.Body.Close()
+			delivery.LastStatusCode = resp.StatusCode
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				delivery.LastError = resp.Status
+			}
+		}
+	}
+	if err != nil {
+		delivery.LastError = err.Error()
+	}
+	if len(delivery.LastError) > 500 {
+		delivery.LastError = delivery.LastError[:500]
+	}
+
+	var retryAfter time.Duration
+	switch {
+	case delivery.LastError == "":
+		delivery.Status = "succeeded"
+	case delivery.Attempts >= webhookMaxAttempts:
+		delivery.Status = "failed"
+	default:
+		delivery.Status = "pending"
+		retryAfter = webhookRetryAfter(delivery.Attempts)
+	}
+	err = app.models.Webhooks.FinishAttempt(delivery, retryAfter)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"delivery_id": strconv.FormatInt(delivery.ID, 10)})
+	}
+}
+
+// The webhookRetryAfter() function returns how long to wait after a failed
+// attempt: webhookBackoff doubled for each earlier attempt, up to
+// webhookMaxBackoff, give or take a tenth so retries spread out
+func webhookRetryAfter(attempts int) time.Duration {
+	wait := webhookMaxBackoff
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts <= 20 {
+		wait = webhookBackoff << (attempts - 1)
+		if wait > webhookMaxBackoff {
+			wait = webhookMaxBackoff
+		}
+	}
+	jitter := time.Duration(rand.Int63n(int64(wait)/5)) - wait/10
+	return wait + jitter
+}
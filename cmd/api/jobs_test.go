@@ -0,0 +1,189 @@
+// Filename: cmd/api/jobs_test.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/jsonlog"
+)
+
+// The query FinishAttempt() runs
+const finishAttemptQuery = `UPDATE webhook_deliveries\s+SET status = \$1, last_status_code = \$2, last_error = \$3`
+
+// The newMockApp() function returns an application with a mock database
+func newMockApp(t *testing.T) (*application, sqlmock.Sqlmock, *bytes.Buffer) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	var logs bytes.Buffer
+	app := &application{
+		logger: jsonlog.New(&logs, jsonlog.LevelInfo),
+		models: data.NewModels(db),
+	}
+	return app, mock, &logs
+}
+
+// The newDelivery() function returns a claimed delivery to url
+func newDelivery(url string, attempts int) *data.Delivery {
+	return &data.Delivery{
+		ID:       42,
+		Attempts: attempts,
+		URL:      url,
+		Secret:   "whsec_test",
+		Event: data.Event{
+			ID:      9,
+			Type:    data.EventToastUpdated,
+			ToastID: 7,
+			Toast:   []byte(`{"id":7,"name":"St. John's College"}`),
+		},
+	}
+}
+
+// A backoffArg matches the seconds FinishAttempt() is told to wait, which
+// webhookRetryAfter() spreads by up to a tenth either way
+type backoffArg struct {
+	wait time.Duration
+}
+
+func (a backoffArg) Match(v driver.Value) bool {
+	secs, ok := v.(float64)
+	if !ok {
+		return false
+	}
+	got := time.Duration(secs * float64(time.Second))
+	return got >= a.wait-a.wait/10 && got <= a.wait+a.wait/10
+}
+
+func TestSendDeliverySigned(t *testing.T) {
+	app, mock, logs := newMockApp(t)
+	var header string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Toaster-Signature")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	mock.ExpectExec(finishAttemptQuery).
+		WithArgs("succeeded", http.StatusNoContent, "", float64(0), int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	app.sendDelivery(newDelivery(srv.URL, 1))
+
+	// The header is "t=<unix time>,v1=<hex HMAC-SHA256 of time.body>"
+	parts := strings.Split(header, ",")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") || !strings.HasPrefix(parts[1], "v1=") {
+		t.Fatalf("X-Toaster-Signature is %q", header)
+	}
+	timestamp := strings.TrimPrefix(parts[0], "t=")
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if want := hex.EncodeToString(mac.Sum(nil)); strings.TrimPrefix(parts[1], "v1=") != want {
+		t.Errorf("signature is %q, want %q", parts[1], "v1="+want)
+	}
+	if !bytes.Contains(body, []byte(`"type":"toast.updated"`)) {
+		t.Errorf("body is %s", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if logs.Len() > 0 {
+		t.Errorf("unexpected log output: %s", logs)
+	}
+}
+
+func TestSendDeliveryRetries(t *testing.T) {
+	app, mock, _ := newMockApp(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	// A failed third attempt waits four times the first backoff
+	mock.ExpectExec(finishAttemptQuery).
+		WithArgs("pending", http.StatusInternalServerError, "500 Internal Server Error",
+			backoffArg{webhookBackoff * 4}, int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	delivery := newDelivery(srv.URL, 3)
+	app.sendDelivery(delivery)
+
+	if delivery.Status != "pending" {
+		t.Errorf("status is %q, want pending", delivery.Status)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSendDeliveryGivesUp(t *testing.T) {
+	app, mock, _ := newMockApp(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	// The last attempt failing fails the delivery, with no retry
+	mock.ExpectExec(finishAttemptQuery).
+		WithArgs("failed", http.StatusInternalServerError, "500 Internal Server Error", float64(0), int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	delivery := newDelivery(srv.URL, webhookMaxAttempts)
+	app.sendDelivery(delivery)
+
+	if delivery.Status != "failed" {
+		t.Errorf("status is %q, want failed", delivery.Status)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSendDeliveryNoRedirects(t *testing.T) {
+	app, mock, _ := newMockApp(t)
+	var redirected int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/elsewhere", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&redirected, 1)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// The redirect is an unsuccessful response, not a new destination
+	mock.ExpectExec(finishAttemptQuery).
+		WithArgs("pending", http.StatusTemporaryRedirect, "307 Temporary Redirect",
+			backoffArg{webhookBackoff}, int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	app.sendDelivery(newDelivery(srv.URL+"/hook", 1))
+
+	if n := atomic.LoadInt32(&redirected); n != 0 {
+		t.Errorf("the redirect was followed %d times", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
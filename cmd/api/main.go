@@ -3,17 +3,26 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
 	"time"
+	// Time zones of opening hours are checked against the embedded database
+	_ "time/tzdata"
 
+	"github.com/graphql-go/graphql"
 	_ "github.com/lib/pq"
 	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/geocoder"
 	"toaster.jalen.net/internals/jsonlog"
 	"toaster.jalen.net/internals/mailer"
+	"toaster.jalen.net/internals/phone"
+	"toaster.jalen.net/internals/storage"
 )
 
 // The application version number
@@ -23,7 +32,10 @@ const version = "1.0.0"
 type config struct {
 	port int
 	env  string // development, staging, production, etc.
-	db   struct {
+	grpc struct {
+		port int // 0 turns the gRPC server off
+	}
+	db struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
@@ -44,21 +56,59 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	geocoder struct {
+		file string
+	}
+	phone struct {
+		region   string // region of numbers without a country code
+		backfill bool   // work out the E.164 forms stored toasts lack
+	}
+	storage struct {
+		backend string // local or s3
+		dir     string
+		s3      struct {
+			endpoint  string
+			region    string
+			bucket    string
+			accessKey string
+			secretKey string
+		}
+	}
+	locales struct {
+		supported []string // the first is the language of the toasts' own names
+	}
+	attachments struct {
+		maxBytes int64
+		secret   string // signs download links
+		urlTTL   time.Duration
+	}
 }
 
 // Dependency Injection
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config   config
+	logger   *jsonlog.Logger
+	models   data.Models
+	mailer   mailer.Mailer
+	geocoder geocoder.Geocoder
+	storage  storage.Storage
+	// The allowed toast levels and modes
+	vocabularies vocabularyCache
+	// The open event streams
+	streams streamHub
+	// The schema of the GraphQL endpoint
+	graphql graphql.Schema
+	wg      sync.WaitGroup
+	// Closed when the server starts shutting down, to stop the workers
+	// that run for as long as the server does
+	shutdown chan struct{}
 }
 
 func main() {
 	var cfg config
 	// read in the flags that are needed to populate our config
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
+	flag.IntVar(&cfg.grpc.port, "grpc-port", 4001, "gRPC server port (0 to disable)")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development | staging | production)")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("TOASTER_DB_DSN"), "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
@@ -81,9 +131,48 @@ func main() {
 		return nil
 	})
 
+	// This flag is for the geocoder. Without it toasts are not geocoded
+	flag.StringVar(&cfg.geocoder.file, "geocoder-file", "", "JSON file of geocoded addresses")
+
+	// This flag is for reading phone numbers without a country code
+	flag.StringVar(&cfg.phone.region, "phone-region", "US", "Default phone number region (ISO 3166 country code)")
+	flag.BoolVar(&cfg.phone.backfill, "phone-backfill", false, "Work out the E.164 form of stored phone numbers that lack one (once, after migration 000012)")
+
+	// These flags are for storing attachments
+	flag.StringVar(&cfg.storage.backend, "storage", "local", "Attachment storage (local | s3)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory for local attachment storage")
+	flag.StringVar(&cfg.storage.s3.endpoint, "s3-endpoint", "https://s3.amazonaws.com", "S3-compatible storage endpoint")
+	flag.StringVar(&cfg.storage.s3.region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.bucket, "s3-bucket", "", "S3 bucket")
+	flag.StringVar(&cfg.storage.s3.accessKey, "s3-access-key", os.Getenv("TOASTER_S3_ACCESS_KEY"), "S3 access key")
+	flag.StringVar(&cfg.storage.s3.secretKey, "s3-secret-key", os.Getenv("TOASTER_S3_SECRET_KEY"), "S3 secret key")
+	flag.Int64Var(&cfg.attachments.maxBytes, "attachments-max-bytes", 10_485_760, "Attachment maximum size in bytes")
+	flag.StringVar(&cfg.attachments.secret, "attachments-secret", os.Getenv("TOASTER_ATTACHMENTS_SECRET"), "Key for signing attachment download links")
+	flag.DurationVar(&cfg.attachments.urlTTL, "attachments-url-ttl", 5*time.Minute, "Attachment download link lifetime")
+
+	// This flag is for the languages toasts are published in
+	cfg.locales.supported = []string{"en", "es"}
+	flag.Func("locales", "Supported languages, the toasts' own first (space separated, default \"en es\")", func(val string) error {
+		cfg.locales.supported = strings.Fields(strings.ToLower(val))
+		return nil
+	})
+
 	flag.Parse()
 	// Create a logger
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	// Check the locales, which must include the toasts' own
+	if len(cfg.locales.supported) == 0 {
+		logger.PrintFatal(errors.New("-locales must list at least one language"), nil)
+	}
+	for _, locale := range cfg.locales.supported {
+		if !data.LocaleRX.MatchString(locale) {
+			logger.PrintFatal(fmt.Errorf("invalid locale %q in -locales", locale), nil)
+		}
+	}
+	// Check the phone region before it is needed
+	if !phone.ValidRegion(cfg.phone.region) {
+		logger.PrintFatal(fmt.Errorf("invalid -phone-region %q", cfg.phone.region), nil)
+	}
 	// Create the connection pool
 	db, err := openDB(cfg)
 	if err != nil {
@@ -95,11 +184,49 @@ func main() {
 	logger.PrintInfo("database connection pool established", nil)
 	// Create an instance of our application struct
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		shutdown: make(chan struct{}),
+	}
+	// Load the geocoder
+	if cfg.geocoder.file != "" {
+		app.geocoder, err = geocoder.NewFile(cfg.geocoder.file)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
 	}
+	// Open the attachment storage
+	app.storage, err = openStorage(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	// Without a configured key, download links only last until a restart
+	if app.config.attachments.secret == "" {
+		key := make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		app.config.attachments.secret = string(key)
+		logger.PrintInfo("no -attachments-secret set, download links will not survive a restart", nil)
+	}
+	// Build the GraphQL schema
+	app.graphql, err = app.newGraphQLSchema()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	// Work out the E.164 forms of the phone numbers stored before them
+	if cfg.phone.backfill {
+		app.background(app.backfillPhones)
+	}
+	// Record the toasts stored before their changes were
+	app.background(app.backfillEvents)
+	// Send the webhook deliveries queued by toast changes
+	app.background(app.deliverWebhooks)
+	// Wake the event streams when toasts change
+	app.background(app.listenToastChanges)
 	// Call app.serve() to start the server
 	err = app.serve()
 	if err != nil {
@@ -108,6 +235,19 @@ func main() {
 
 }
 
+// The openStorage() function returns the storage for attachments
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		return storage.NewLocal(cfg.storage.dir)
+	case "s3":
+		s3 := cfg.storage.s3
+		return storage.NewS3(s3.endpoint, s3.region, s3.bucket, s3.accessKey, s3.secretKey)
+	default:
+		return nil, fmt.Errorf("invalid -storage %q", cfg.storage.backend)
+	}
+}
+
 // The openDB() function returns a *sql.DB connection pool
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
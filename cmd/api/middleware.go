@@ -28,32 +28,65 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	// Create a client type
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+// A clientLimiter limits how often each client, named by its IP address,
+// makes requests, with a token bucket each
+type clientLimiter struct {
+	rps     float64 // requests/second
+	burst   int
+	mu      sync.Mutex
+	clients map[string]*limitedClient
+}
+
+// A limitedClient is the token bucket of one client
+type limitedClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// The newClientLimiter() method returns a clientLimiter with the rate
+// limiter settings
+func (app *application) newClientLimiter() *clientLimiter {
+	l := &clientLimiter{
+		rps:     app.config.limiter.rps,
+		burst:   app.config.limiter.burst,
+		clients: make(map[string]*limitedClient),
 	}
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
 	// Launch a backaground Goroutine that removes old entries
 	// from the clients map once every minute
 	go func() {
 		for {
 			time.Sleep(time.Minute)
 			// Lock before starting to cleanup
-			mu.Lock()
-			for ip, client := range clients {
+			l.mu.Lock()
+			for ip, client := range l.clients {
 				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
+					delete(l.clients, ip)
 				}
 			}
-			mu.Unlock()
+			l.mu.Unlock()
 		}
 	}()
+	return l
+}
 
+// The allow() method reports whether the client with the IP address may
+// make another request now
+func (l *clientLimiter) allow(ip string) bool {
+	// Lock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Check if the IP address is in the map
+	if _, found := l.clients[ip]; !found {
+		l.clients[ip] = &limitedClient{limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst)}
+	}
+	// Update the last seen time of the client
+	l.clients[ip].lastSeen = time.Now()
+	// Check if request allowed
+	return l.clients[ip].limiter.Allow()
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	limiter := app.newClientLimiter()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if app.config.limiter.enabled {
 			// Get the IP address of the request
@@ -62,25 +95,10 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 				app.serverErrorResponse(w, r, err)
 				return
 			}
-			// Lock()
-			mu.Lock()
-			// Check if the IP address is in the map
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{limiter: rate.NewLimiter(
-					rate.Limit(app.config.limiter.rps),
-					app.config.limiter.burst,
-				)}
-			}
-			// Update the last seen time of the client
-			clients[ip].lastSeen = time.Now()
-			// Check if request allowed
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
+			if !limiter.allow(ip) {
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
-
-			mu.Unlock()
 		} // end of enabled conditional
 		next.ServeHTTP(w, r)
 	})
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"toaster.jalen.net/internals/data"
 )
 
 func (app *application) routes() http.Handler {
@@ -17,11 +18,70 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/toasts", app.requirePermission("toasts:read", app.listToastsHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/toasts", app.requirePermission("toasts:write", app.createToastHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/toasts/:id", app.requirePermission("toasts:read", app.showToastHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/toasts/:id", app.requirePermission("toasts:write", app.replaceToastHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/toasts/:id", app.requirePermission("toasts:write", app.updateToastHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/toasts/:id", app.requirePermission("toasts:write", app.deleteToastHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/toasts/:id/merge", app.requirePermission("toasts:admin", app.mergeToastsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/toasts/:id/contacts", app.requirePermission("toasts:read", app.listContactsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/toasts/:id/contacts", app.requirePermission("toasts:write", app.createContactHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/toasts/:id/contacts/:contact_id", app.requirePermission("toasts:read", app.showContactHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/toasts/:id/contacts/:contact_id", app.requirePermission("toasts:write", app.updateContactHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/toasts/:id/contacts/:contact_id", app.requirePermission("toasts:write", app.deleteContactHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/toasts/:id/attachments", app.requirePermission("toasts:read", app.listAttachmentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/toasts/:id/attachments", app.requirePermission("toasts:write", app.createAttachmentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/toasts/:id/attachments/:attachment_id", app.requirePermission("toasts:read", app.showAttachmentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/toasts/:id/attachments/:attachment_id", app.requirePermission("toasts:write", app.deleteAttachmentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/toasts/:id/translations", app.requirePermission("toasts:read", app.listTranslationsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/toasts/:id/translations/:locale", app.requirePermission("toasts:write", app.putTranslationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/toasts/:id/translations/:locale", app.requirePermission("toasts:write", app.deleteTranslationHandler))
+	// Download links are signed, so they don't need authentication
+	router.HandlerFunc(http.MethodGet, "/v1/attachments/:id/download", app.downloadAttachmentHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("toasts:admin", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("toasts:admin", app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("toasts:admin", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission("toasts:admin", app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("toasts:admin", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("toasts:admin", app.listDeliveriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/retry", app.requirePermission("toasts:admin", app.retryDeliveryHandler))
+	// The GraphQL resolvers check permissions themselves
+	router.HandlerFunc(http.MethodGet, "/v1/graphql", app.graphqlHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/graphql", app.graphqlHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users", app.requirePermission("toasts:admin", app.listUsersHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	// httprouter does not allow a fixed path segment in the same position
+	// as the :id wildcard, so those routes live on their own router which
+	// is checked first
+	named := httprouter.New()
+	named.HandlerFunc(http.MethodGet, "/v1/toasts/stats", app.requirePermission("toasts:read", app.statsToastsHandler))
+	named.HandlerFunc(http.MethodGet, "/v1/toasts/changes", app.requirePermission("toasts:read", app.listChangesHandler))
+	named.HandlerFunc(http.MethodGet, "/v1/toasts/events", app.requirePermission("toasts:read", app.streamToastEventsHandler))
+	named.HandlerFunc(http.MethodGet, "/v1/toasts/suggest", app.requirePermission("toasts:read", app.suggestToastsHandler))
+	named.HandlerFunc(http.MethodGet, "/v1/toasts/by-external/:source/:external_id", app.requirePermission("toasts:read", app.showToastByExternalIDHandler))
+	named.HandlerFunc(http.MethodPut, "/v1/toasts/by-external/:source/:external_id", app.requirePermission("toasts:write", app.upsertToastByExternalIDHandler))
+	named.HandlerFunc(http.MethodGet, "/v1/toasts/attributes", app.requirePermission("toasts:read", app.listAttributesHandler))
+	named.HandlerFunc(http.MethodPut, "/v1/toasts/attributes/:name", app.requirePermission("toasts:admin", app.putAttributeHandler))
+	named.HandlerFunc(http.MethodDelete, "/v1/toasts/attributes/:name", app.requirePermission("toasts:admin", app.deleteAttributeHandler))
+	named.HandlerFunc(http.MethodGet, "/v1/toasts/duplicates", app.requirePermission("toasts:admin", app.listDuplicatesHandler))
+	named.HandlerFunc(http.MethodGet, "/v1/toasts/levels", app.requirePermission("toasts:read", app.listTermsHandler(data.LevelVocabulary, "levels")))
+	named.HandlerFunc(http.MethodPost, "/v1/toasts/levels", app.requirePermission("vocabularies:write", app.createTermHandler(data.LevelVocabulary, "level")))
+	named.HandlerFunc(http.MethodDelete, "/v1/toasts/levels/:term", app.requirePermission("vocabularies:write", app.deleteTermHandler(data.LevelVocabulary, "level")))
+	named.HandlerFunc(http.MethodGet, "/v1/toasts/modes", app.requirePermission("toasts:read", app.listTermsHandler(data.ModeVocabulary, "modes")))
+	named.HandlerFunc(http.MethodPost, "/v1/toasts/modes", app.requirePermission("vocabularies:write", app.createTermHandler(data.ModeVocabulary, "mode")))
+	named.HandlerFunc(http.MethodDelete, "/v1/toasts/modes/:term", app.requirePermission("vocabularies:write", app.deleteTermHandler(data.ModeVocabulary, "mode")))
 
-	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
+	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.namedRoutes(named, router)))))
+}
+
+// The namedRoutes() method sends a request to the named router when it has
+// a route for it and to the main router otherwise
+func (app *application) namedRoutes(named *httprouter.Router, router *httprouter.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle, _, _ := named.Lookup(r.Method, r.URL.Path); handle != nil {
+			named.ServeHTTP(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,6 +13,10 @@ import (
 	"time"
 )
 
+// How long a response may take to write. Event streams end before this
+// and the clients reconnect
+const writeTimeout = 30 * time.Second
+
 func (app *application) serve() error {
 	// Create our HTTP server
 	srv := &http.Server{
@@ -20,7 +25,29 @@ func (app *application) serve() error {
 		ErrorLog:     log.New(app.logger, "", 0),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: writeTimeout,
+	}
+	// Stop the workers and end the event streams as soon as shutdown
+	// starts, as Shutdown() waits for the streams to finish
+	srv.RegisterOnShutdown(func() {
+		close(app.shutdown)
+	})
+	// The gRPC server runs on its own port and stops with the HTTP server
+	grpcSrv := app.newGRPCServer()
+	if app.config.grpc.port != 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", app.config.grpc.port))
+		if err != nil {
+			return err
+		}
+		app.logger.PrintInfo("starting gRPC server", map[string]string{
+			"addr": lis.Addr().String(),
+		})
+		go func() {
+			err := grpcSrv.Serve(lis)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}()
 	}
 	// The Shutdown() function should return its error to this channel
 	shutdownError := make(chan error)
@@ -45,6 +72,18 @@ func (app *application) serve() error {
 		if err != nil {
 			shutdownError <- err
 		}
+		// Let the gRPC calls in progress finish, for as long as the HTTP
+		// requests were given
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcSrv.Stop()
+		}
 		// Log a message about the goroutines
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
@@ -3,11 +3,18 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/geocoder"
+	"toaster.jalen.net/internals/phone"
 	"toaster.jalen.net/internals/validator"
 )
 
@@ -15,14 +22,19 @@ import (
 func (app *application) createToastHandler(w http.ResponseWriter, r *http.Request) {
 	// Our target decode destination
 	var input struct {
-		Name    string   `json:"name"`
-		Level   string   `json:"level"`
-		Contact string   `json:"contact"`
-		Phone   string   `json:"phone"`
-		Email   string   `json:"email"`
-		Website string   `json:"website"`
-		Address string   `json:"address"`
-		Mode    []string `json:"mode"`
+		Name           string             `json:"name"`
+		Level          string             `json:"level"`
+		Contact        string             `json:"contact"`
+		Phone          string             `json:"phone"`
+		Email          string             `json:"email"`
+		Website        string             `json:"website"`
+		Address        string             `json:"address"`
+		Mode           []string           `json:"mode"`
+		Tags           []string           `json:"tags"`
+		Attributes     data.Attributes    `json:"attributes"`
+		Hours          *data.OpeningHours `json:"hours"`
+		ExternalSource string             `json:"external_source"`
+		ExternalID     string             `json:"external_id"`
 	}
 	// Initialize a new json.Decoder instance
 	err := app.readJSON(w, r, &input)
@@ -33,33 +45,43 @@ func (app *application) createToastHandler(w http.ResponseWriter, r *http.Reques
 
 	// Copy the values from the input struct to a new toast struct
 	toast := &data.Toast{
-		Name:    input.Name,
-		Level:   input.Level,
-		Contact: input.Contact,
-		Phone:   input.Phone,
-		Email:   input.Email,
-		Website: input.Website,
-		Address: input.Address,
-		Mode:    input.Mode,
+		Name:           input.Name,
+		Level:          input.Level,
+		Contact:        input.Contact,
+		Phone:          input.Phone,
+		Email:          input.Email,
+		Website:        input.Website,
+		Address:        input.Address,
+		Mode:           input.Mode,
+		Tags:           input.Tags,
+		Attributes:     input.Attributes,
+		Hours:          input.Hours,
+		ExternalSource: input.ExternalSource,
+		ExternalID:     input.ExternalID,
+		CreatedBy:      &app.contextGetUser(r).ID,
 	}
 
 	// Initialize a new Validator instance
 	v := validator.New()
-
-	// Check the map to determine if there were any validation errors
-	if data.ValidateToast(v, toast); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-
-	// Create a toast
-	err = app.models.Toasts.Insert(toast)
+	// A client that knows the toast looks like another can create it anyway
+	force := app.readBool(r.URL.Query(), "force", false, v)
+	// Create a toast. A bad force value is reported with the toast's errors
+	duplicates, err := app.createToast(toast, force, v)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, errFailedValidation):
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errDuplicateToast):
+			app.duplicateToastResponse(w, r, duplicates)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 	// Create a Location header for the newly created resource/toast
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/toasts/%d", toast.ID))
+	headers.Set("ETag", etag(toast.Version))
 	// Write the JSON response with 201 - Created status code with the body
 	// being the toast data and the header being the headers map
 	err = app.writeJSON(w, http.StatusCreated, envelope{"toast": toast}, headers)
@@ -75,9 +97,22 @@ func (app *application) showToastHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
+	// Get the fields the client wants back. None means all of them
+	fields := app.readCSV(r.URL.Query(), "fields", nil)
+	// Get the related records to embed in the toast
+	embed := app.readCSV(r.URL.Query(), "embed", nil)
+	v := validator.New()
+	data.ValidateFields(v, fields, data.ToastFields)
+	for _, name := range embed {
+		v.Check(validator.In(name, "contacts"), "embed", fmt.Sprintf("invalid value %q", name))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	// Fetch the specific toast
-	toast, err := app.models.Toasts.Get(id)
+	toast, err := app.models.Toasts.GetFields(id, fields)
 	// Handle errors
 	if err != nil {
 		switch {
@@ -88,8 +123,59 @@ func (app *application) showToastHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	// Read the contacts to embed. They are kept when only some fields are
+	// returned
+	embedContacts := validator.In("contacts", embed...)
+	if embedContacts {
+		toast.Contacts, err = app.models.Contacts.GetAllForToast(toast.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if len(fields) > 0 {
+			fields = append(fields, "contacts")
+		}
+	}
+	// Put the name and address in the client's language
+	locale, err := app.translateToasts(w, r, toast)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	translated := locale != app.config.locales.supported[0]
+	// Say whether the toast is open, when we know its hours
+	if toast.Hours != nil {
+		openNow := toast.Hours.OpenAt(time.Now())
+		toast.OpenNow = &openNow
+		if len(fields) > 0 {
+			fields = append(fields, "open_now")
+		}
+	}
+	// The version number doubles as the entity tag. If the client already
+	// holds this version we send a 304 - Not Modified without a body.
+	// Embedded contacts, translations and whether the toast is open change
+	// without changing the version, so they are always sent. A translation
+	// gets a tag of its own
+	tag := etag(toast.Version)
+	if translated {
+		tag = translatedETag(toast.Version, locale)
+	}
+	fresh := !embedContacts && !translated && toast.OpenNow == nil
+	if match := r.Header.Get("If-None-Match"); match != "" && fresh && etagMatches(match, tag, true) {
+		w.Header().Set("ETag", tag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", tag)
+	// Leave out the fields the client did not ask for
+	selected, err := app.selectFields(toast, fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// Write the data returned by Get()
-	err = app.writeJSON(w, http.StatusOK, envelope{"toast": toast}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"toast": selected}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -115,76 +201,277 @@ func (app *application) updateToastHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	// Create an input struct to hold data read in from the client
-	// We update input struct to use pointers because pointers have a
-	// default value of nil
-	// If a field remains nil then we know that the client did not update it
-	var input struct {
-		Name    *string  `json:"name"`
-		Level   *string  `json:"level"`
-		Contact *string  `json:"contact"`
-		Phone   *string  `json:"phone"`
-		Email   *string  `json:"email"`
-		Website *string  `json:"website"`
-		Address *string  `json:"address"`
-		Mode    []string `json:"mode"`
+	// The client must tell us which version it is editing. If that is no
+	// longer the current version we send a 412 - Precondition Failed
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		app.preconditionRequiredResponse(w, r)
+		return
 	}
-
-	// Initialize a new json.Decoder instance
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	if !etagMatches(match, etag(toast.Version), false) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
+	// Apply the changes in the request body. A JSON body is a partial update,
+	// while merge patches and JSON patches are applied to the toast's JSON
+	// document
+	switch mediaType := app.readMediaType(r); mediaType {
+	case mediaTypeMergePatch, mediaTypeJSONPatch:
+		// Only the fields a client can write are patched. Any other field
+		// the patch adds is rejected as an unknown key
+		var patched toastDocument
+		err = app.readPatch(w, r, mediaType, newToastDocument(toast), &patched)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		// The id and version are managed by us
+		if patched.ID != toast.ID || patched.Version != toast.Version {
+			app.badRequestResponse(w, r, errors.New("body must not change the id or version"))
+			return
+		}
+		patched.apply(toast)
+	case mediaTypeJSON:
+		// Create an input struct to hold data read in from the client
+		// We update input struct to use pointers because pointers have a
+		// default value of nil
+		// If a field remains nil then we know that the client did not update it
+		var input struct {
+			Name           *string            `json:"name"`
+			Level          *string            `json:"level"`
+			Contact        *string            `json:"contact"`
+			Phone          *string            `json:"phone"`
+			Email          *string            `json:"email"`
+			Website        *string            `json:"website"`
+			Address        *string            `json:"address"`
+			Mode           []string           `json:"mode"`
+			Tags           []string           `json:"tags"`
+			Attributes     *data.Attributes   `json:"attributes"`
+			Hours          *data.OpeningHours `json:"hours"`
+			ExternalSource *string            `json:"external_source"`
+			ExternalID     *string            `json:"external_id"`
+		}
 
-	// Check for updates
-	if input.Name != nil {
-		toast.Name = *input.Name
+		// Initialize a new json.Decoder instance
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// Check for updates
+		if input.Name != nil {
+			toast.Name = *input.Name
+		}
+		if input.Level != nil {
+			toast.Level = *input.Level
+		}
+		if input.Contact != nil {
+			toast.Contact = *input.Contact
+		}
+		if input.Phone != nil {
+			toast.Phone = *input.Phone
+		}
+		if input.Email != nil {
+			toast.Email = *input.Email
+		}
+		if input.Website != nil {
+			toast.Website = *input.Website
+		}
+		if input.Address != nil {
+			toast.Address = *input.Address
+		}
+		if input.Mode != nil {
+			toast.Mode = input.Mode
+		}
+		if input.Tags != nil {
+			toast.Tags = input.Tags
+		}
+		if input.Attributes != nil {
+			toast.Attributes = *input.Attributes
+		}
+		if input.Hours != nil {
+			toast.Hours = input.Hours
+		}
+		if input.ExternalSource != nil {
+			toast.ExternalSource = *input.ExternalSource
+		}
+		if input.ExternalID != nil {
+			toast.ExternalID = *input.ExternalID
+		}
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
 	}
-	if input.Level != nil {
-		toast.Level = *input.Level
+	// Validate and save the updated Toast. If validation fails, then we
+	// send a 422 - Unprocessable Entity respose to the client. An edit
+	// conflict means the version the client matched has changed since we
+	// read it
+	v := validator.New()
+	err = app.updateToast(toast, v)
+	if err != nil {
+		switch {
+		case errors.Is(err, errFailedValidation):
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
-	if input.Contact != nil {
-		toast.Contact = *input.Contact
+	// Send the new entity tag along with the updated toast
+	headers := make(http.Header)
+	headers.Set("ETag", etag(toast.Version))
+	// Write the data returned by Get()
+	err = app.writeJSON(w, http.StatusOK, envelope{"toast": toast}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
-	if input.Phone != nil {
-		toast.Phone = *input.Phone
+}
+
+// A toastDocument is the JSON document of a toast that merge patches and
+// JSON patches are applied to. It holds the fields a client can write, and
+// the id and version so a patch can test them
+type toastDocument struct {
+	ID             int64              `json:"id"`
+	Version        int32              `json:"version"`
+	Name           string             `json:"name"`
+	Level          string             `json:"level"`
+	Contact        string             `json:"contact"`
+	Phone          string             `json:"phone"`
+	Email          string             `json:"email"`
+	Website        string             `json:"website"`
+	Address        string             `json:"address"`
+	Mode           []string           `json:"mode"`
+	Tags           []string           `json:"tags"`
+	Attributes     data.Attributes    `json:"attributes"`
+	Hours          *data.OpeningHours `json:"hours"`
+	ExternalSource string             `json:"external_source"`
+	ExternalID     string             `json:"external_id"`
+}
+
+// The newToastDocument() function returns the document of a toast
+func newToastDocument(toast *data.Toast) toastDocument {
+	return toastDocument{
+		ID:             toast.ID,
+		Version:        toast.Version,
+		Name:           toast.Name,
+		Level:          toast.Level,
+		Contact:        toast.Contact,
+		Phone:          toast.Phone,
+		Email:          toast.Email,
+		Website:        toast.Website,
+		Address:        toast.Address,
+		Mode:           toast.Mode,
+		Tags:           toast.Tags,
+		Attributes:     toast.Attributes,
+		Hours:          toast.Hours,
+		ExternalSource: toast.ExternalSource,
+		ExternalID:     toast.ExternalID,
+	}
+}
+
+// The apply() method copies the writable fields of the document to a toast
+func (doc toastDocument) apply(toast *data.Toast) {
+	toast.Name = doc.Name
+	toast.Level = doc.Level
+	toast.Contact = doc.Contact
+	toast.Phone = doc.Phone
+	toast.Email = doc.Email
+	toast.Website = doc.Website
+	toast.Address = doc.Address
+	toast.Mode = doc.Mode
+	toast.Tags = doc.Tags
+	toast.Attributes = doc.Attributes
+	toast.Hours = doc.Hours
+	toast.ExternalSource = doc.ExternalSource
+	toast.ExternalID = doc.ExternalID
+}
+
+// replaceToastHandler for the "PUT /v1/toasts/:id" endpoint
+func (app *application) replaceToastHandler(w http.ResponseWriter, r *http.Request) {
+	// This method does a full replacement
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
 	}
-	if input.Email != nil {
-		toast.Email = *input.Email
+	// Fetch the orginal record from the database
+	toast, err := app.models.Toasts.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
-	if input.Website != nil {
-		toast.Website = *input.Website
+	// The client must tell us which version it is replacing
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		app.preconditionRequiredResponse(w, r)
+		return
 	}
-	if input.Address != nil {
-		toast.Address = *input.Address
+	if !etagMatches(match, etag(toast.Version), false) {
+		app.preconditionFailedResponse(w, r)
+		return
 	}
-	if input.Mode != nil {
-		toast.Mode = input.Mode
+	// Every field is replaced so we don't use pointers here. Missing
+	// fields are left empty and rejected by ValidateToast()
+	var input struct {
+		Name           string             `json:"name"`
+		Level          string             `json:"level"`
+		Contact        string             `json:"contact"`
+		Phone          string             `json:"phone"`
+		Email          string             `json:"email"`
+		Website        string             `json:"website"`
+		Address        string             `json:"address"`
+		Mode           []string           `json:"mode"`
+		Tags           []string           `json:"tags"`
+		Attributes     data.Attributes    `json:"attributes"`
+		Hours          *data.OpeningHours `json:"hours"`
+		ExternalSource string             `json:"external_source"`
+		ExternalID     string             `json:"external_id"`
 	}
-	// Perform validation on the updated Toast. If validation fails, then
-	// we send a 422 - Unprocessable Entity respose to the client
-	// Initialize a new Validator instance
-	v := validator.New()
-
-	// Check the map to determine if there were any validation errors
-	if data.ValidateToast(v, toast); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
-	// Pass the updated Toast record to the Update() method
-	err = app.models.Toasts.Update(toast)
+	// Replace the values of the toast
+	toast.Name = input.Name
+	toast.Level = input.Level
+	toast.Contact = input.Contact
+	toast.Phone = input.Phone
+	toast.Email = input.Email
+	toast.Website = input.Website
+	toast.Address = input.Address
+	toast.Mode = input.Mode
+	toast.Tags = input.Tags
+	toast.Attributes = input.Attributes
+	toast.Hours = input.Hours
+	toast.ExternalSource = input.ExternalSource
+	toast.ExternalID = input.ExternalID
+	// Validate and save the replaced Toast
+	v := validator.New()
+	err = app.updateToast(toast, v)
 	if err != nil {
 		switch {
+		case errors.Is(err, errFailedValidation):
+			app.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	// Write the data returned by Get()
-	err = app.writeJSON(w, http.StatusOK, envelope{"toast": toast}, nil)
+	// Send the new entity tag along with the replaced toast
+	headers := make(http.Header)
+	headers.Set("ETag", etag(toast.Version))
+	err = app.writeJSON(w, http.StatusOK, envelope{"toast": toast}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -197,10 +484,9 @@ func (app *application) deleteToastHandler(w http.ResponseWriter, r *http.Reques
 		app.notFoundResponse(w, r)
 		return
 	}
-	// Delete the Toast from the database. Send a 404 Not Found status code to the
+	// Fetch the current record. Send a 404 Not Found status code to the
 	// client if there is no matching record
-	err = app.models.Toasts.Delete(id)
-	// Handle errors
+	toast, err := app.models.Toasts.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -210,6 +496,28 @@ func (app *application) deleteToastHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
+	// The client must tell us which version it is deleting
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		app.preconditionRequiredResponse(w, r)
+		return
+	}
+	if !etagMatches(match, etag(toast.Version), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	// Delete the Toast and its stored files
+	err = app.deleteToast(toast)
+	// Handle errors
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 	// Return 200 Status OK to the client with a success message
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "toast successfully deleted"}, nil)
 	if err != nil {
@@ -223,9 +531,7 @@ func (app *application) deleteToastHandler(w http.ResponseWriter, r *http.Reques
 func (app *application) listToastsHandler(w http.ResponseWriter, r *http.Request) {
 	// Create an input struct to hold our query parameters
 	var input struct {
-		Name  string
-		Level string
-		Mode  []string
+		data.ToastSearch
 		data.Filters
 	}
 	// Initialize a validator
@@ -233,31 +539,497 @@ func (app *application) listToastsHandler(w http.ResponseWriter, r *http.Request
 	// Get the URL values map
 	qs := r.URL.Query()
 	// Use the helper methods to extract the values
-	input.Name = app.readString(qs, "name", "")
-	input.Level = app.readString(qs, "level", "")
-	input.Mode = app.readCSV(qs, "mode", []string{})
+	input.ToastSearch = app.readToastSearch(qs, v)
+	// Include the counts of the matching toasts, for faceted search
+	facets := app.readBool(qs, "facets", false, v)
+	// Get the page, sort, filter and fields
+	filters, err := app.readToastFilters(qs, input.ToastSearch, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.Filters = filters
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Get a listing of all toasts
+	toasts, metadata, err := app.models.Toasts.GetAll(input.ToastSearch, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Put the names and addresses in the client's language
+	_, err = app.translateToasts(w, r, toasts...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Leave out the fields the client did not ask for
+	selected := make([]interface{}, len(toasts))
+	for i := range toasts {
+		selected[i], err = app.selectFields(toasts[i], input.Filters.Fields)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	env := envelope{"toasts": selected, "metadata": metadata}
+	// The facets count every matching toast, not just this page
+	if facets {
+		env["facets"], err = app.models.Toasts.GetStats(input.ToastSearch, input.Filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	// Send a JSON response containg all the toasts
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// statsToastsHandler for the "GET /v1/toasts/stats" endpoint. It takes the
+// same search parameters and filter expression as the toast listing
+func (app *application) statsToastsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.ToastSearch
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.ToastSearch = app.readToastSearch(qs, v)
+	// Only the filter expression applies. The counts are not paged
+	input.Filters.Page = 1
+	input.Filters.PageSize = 1
+	input.Filters.Sort = "id"
+	input.Filters.SortList = []string{"id"}
+	input.Filters.Filter = app.readString(qs, "filter", "")
+	filterFields, err := app.toastFilterFields()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.Filters.FilterFields = filterFields
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	stats, err := app.models.Toasts.GetStats(input.ToastSearch, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The toastFilterFields() method returns the fields of a toast that can be
+// used in a filter expression, including the custom attributes
+func (app *application) toastFilterFields() (data.FilterFields, error) {
+	vocabulary, err := app.vocabulary()
+	if err != nil {
+		return nil, err
+	}
+	return data.ToastFilterFieldsFor(vocabulary), nil
+}
+
+// The readToastFilters() method reads the page, sort order, filter
+// expression and fields of a toast listing. Invalid values are added to the
+// validation errors map
+func (app *application) readToastFilters(qs url.Values, search data.ToastSearch, v *validator.Validator) (data.Filters, error) {
+	var filters data.Filters
 	// Get the page information
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	// Get the sort information
-	input.Filters.Sort = app.readString(qs, "sort", "id")
+	filters.Sort = app.readString(qs, "sort", "id")
+	// Get the cursors for keyset pagination
+	filters.After = app.readString(qs, "after", "")
+	filters.Before = app.readString(qs, "before", "")
+	// Get the filter expression and the fields it may use
+	filters.Filter = app.readString(qs, "filter", "")
+	filterFields, err := app.toastFilterFields()
+	if err != nil {
+		return data.Filters{}, err
+	}
+	filters.FilterFields = filterFields
+	// Get the fields to return
+	filters.Fields = app.readCSV(qs, "fields", nil)
+	filters.FieldList = data.ToastFields
 	// Specific the allowed sort values
-	input.Filters.SortList = []string{"id", "name", "level", "-id", "-name", "-level"}
+	filters.SortList = []string{
+		"id", "name", "level", "created_at", "updated_at", "relevance", "distance",
+		"-id", "-name", "-level", "-created_at", "-updated_at",
+	}
+	// Relevance and distance are worked out for each search, so they can't
+	// be combined with other keys or used with cursors
+	for _, computed := range []string{"relevance", "distance"} {
+		if strings.Contains(filters.Sort, computed) {
+			v.Check(filters.Sort == computed, "sort", computed+" must be the only sort key")
+			v.Check(filters.After == "" && filters.Before == "", "sort", computed+" cannot be used with cursors")
+		}
+	}
+	v.Check(filters.Sort != "relevance" || search.Search != "" || search.NameFuzzy != "", "sort", "relevance requires a q or name_fuzzy parameter")
+	v.Check(filters.Sort != "distance" || search.Near, "sort", "distance requires a near parameter")
+	return filters, nil
+}
+
+// The readToastSearch() method reads the parameters that pick which toasts
+// a listing covers. Invalid values are added to the validation errors map
+func (app *application) readToastSearch(qs url.Values, v *validator.Validator) data.ToastSearch {
+	var search data.ToastSearch
+	search.Name = app.readString(qs, "name", "")
+	search.Level = app.readString(qs, "level", "")
+	search.Mode = app.readCSV(qs, "mode", []string{})
+	// Tags are stored in lower case, so they are searched for in it too
+	search.Tags = app.readCSV(qs, "tag", []string{})
+	for i := range search.Tags {
+		search.Tags[i] = strings.ToLower(strings.TrimSpace(search.Tags[i]))
+	}
+	// Any formatting of a phone number finds the toast
+	if number := app.readString(qs, "phone", ""); number != "" {
+		search.Phone = app.readPhone(number, "phone", v)
+	}
+	search.Search = app.readString(qs, "q", "")
+	search.NameFuzzy = app.readString(qs, "name_fuzzy", "")
+	search.Similarity = app.readFloat(qs, "similarity", 0.3, v)
+	// Get the point to search around, given as "lat,lng"
+	if near := app.readCSV(qs, "near", nil); near != nil {
+		search.Near = true
+		search.Latitude, search.Longitude = app.readLatLng(near, "near", v)
+		search.RadiusKm = app.readFloat(qs, "radius_km", 10, v)
+	}
+	// Get the time the toasts must be open at. open_now is the present
+	openNow := app.readBool(qs, "open_now", false, v)
+	if openAt := app.readString(qs, "open_at", ""); openAt != "" {
+		t, err := time.Parse(time.RFC3339, openAt)
+		v.Check(err == nil, "open_at", "must be a time in RFC 3339 format")
+		v.Check(!openNow, "open_at", "cannot be used with open_now")
+		search.OpenAt = &t
+	} else if openNow {
+		t := time.Now()
+		search.OpenAt = &t
+	}
 	// Check for validation errors
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	v.Check(search.Similarity > 0 && search.Similarity <= 1, "similarity", "must be greater than zero and at most 1")
+	v.Check(search.RadiusKm > 0 || !search.Near, "radius_km", "must be greater than zero")
+	v.Check(search.RadiusKm <= 1000, "radius_km", "must be a maximum of 1000")
+	return search
+}
+
+// showToastByExternalIDHandler for the "GET /v1/toasts/by-external/:source/:external_id" endpoint
+func (app *application) showToastByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	source, externalID := app.readExternalIDParams(r)
+	// Fetch the toast with that external id
+	toast, err := app.models.Toasts.GetByExternalID(source, externalID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Point the client at the canonical location of the toast
+	headers := make(http.Header)
+	headers.Set("Content-Location", fmt.Sprintf("/v1/toasts/%d", toast.ID))
+	headers.Set("ETag", etag(toast.Version))
+	err = app.writeJSON(w, http.StatusOK, envelope{"toast": toast}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// upsertToastByExternalIDHandler for the "PUT /v1/toasts/by-external/:source/:external_id"
+// endpoint. Sync jobs can send the same request repeatedly and end up with
+// one toast
+func (app *application) upsertToastByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	source, externalID := app.readExternalIDParams(r)
+	// Every field is replaced and the external id comes from the URL
+	var input struct {
+		Name       string             `json:"name"`
+		Level      string             `json:"level"`
+		Contact    string             `json:"contact"`
+		Phone      string             `json:"phone"`
+		Email      string             `json:"email"`
+		Website    string             `json:"website"`
+		Address    string             `json:"address"`
+		Mode       []string           `json:"mode"`
+		Tags       []string           `json:"tags"`
+		Attributes data.Attributes    `json:"attributes"`
+		Hours      *data.OpeningHours `json:"hours"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	toast := &data.Toast{
+		Name:           input.Name,
+		Level:          input.Level,
+		Contact:        input.Contact,
+		Phone:          input.Phone,
+		Email:          input.Email,
+		Website:        input.Website,
+		Address:        input.Address,
+		Mode:           input.Mode,
+		Tags:           input.Tags,
+		Attributes:     input.Attributes,
+		Hours:          input.Hours,
+		ExternalSource: source,
+		ExternalID:     externalID,
+		CreatedBy:      &app.contextGetUser(r).ID,
+	}
+	// Perform validation on the toast
+	v := validator.New()
+	vocabulary, err := app.normalizeToast(toast)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateToast(v, toast, vocabulary); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	// Get a listing of all toasts
-	toasts, metadata, err := app.models.Toasts.GetAll(input.Name, input.Level, input.Mode, input.Filters)
+	// "If-None-Match: *" only creates a toast and "If-Match" only replaces
+	// the version the client has seen ("*" for any version). Without either
+	// we only create, though sending a stored toast again unchanged is fine
+	var created bool
+	version := int32(0)
+	switch match := r.Header.Get("If-Match"); {
+	case r.Header.Get("If-None-Match") == "*":
+		err = app.models.Toasts.Insert(toast)
+		created = true
+	case match == "*":
+		version = data.AnyVersion
+	case match != "":
+		var ok bool
+		version, ok = etagVersion(match)
+		if !ok {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+	}
+	if !created {
+		created, err = app.models.Toasts.Upsert(toast, version)
+		if version == 0 && errors.Is(err, data.ErrEditConflict) {
+			app.preconditionRequiredResponse(w, r)
+			return
+		}
+	}
+	// Every other conditional failure is a 412 - Precondition Failed
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound),
+			errors.Is(err, data.ErrEditConflict),
+			errors.Is(err, data.ErrDuplicateExternalID):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// New toasts and changed addresses have no location yet
+	if toast.Latitude == nil {
+		app.geocodeToast(toast)
+	}
+	// A new toast gets a 201 - Created with its Location
+	status := http.StatusOK
+	headers := make(http.Header)
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/toasts/%d", toast.ID))
+	}
+	headers.Set("ETag", etag(toast.Version))
+	err = app.writeJSON(w, status, envelope{"toast": toast}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The suggestToastsHandler() returns toast names that start with a prefix
+// for the "GET /v1/toasts/suggest" endpoint
+func (app *application) suggestToastsHandler(w http.ResponseWriter, r *http.Request) {
+	// Initialize a validator
+	v := validator.New()
+	// Get the URL values map
+	qs := r.URL.Query()
+	prefix := app.readString(qs, "prefix", "")
+	limit := app.readInt(qs, "limit", 10, v)
+	// Check for validation errors
+	v.Check(prefix != "", "prefix", "must be provided")
+	v.Check(len(prefix) <= 200, "prefix", "must not be more than 200 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Get the matching names
+	names, err := app.models.Toasts.Suggest(prefix, limit)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Send a JSON response containg all the toasts
-	err = app.writeJSON(w, http.StatusOK, envelope{"toasts": toasts, "metadata": metadata}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": names}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The errors createToast(), updateToast() and deleteToast() return besides
+// those of the models. A failed validation leaves its errors in v
+var (
+	errFailedValidation = errors.New("failed validation")
+	errDuplicateToast   = errors.New("duplicate toast")
+)
+
+// The createToast() method stores a new toast once it is in its standard
+// form and valid, and looks up where it is. Unless force is set, a toast
+// that looks like stored ones is refused with errDuplicateToast and those
+// toasts. Every API creates toasts this way
+func (app *application) createToast(toast *data.Toast, force bool, v *validator.Validator) ([]*data.Duplicate, error) {
+	vocabulary, err := app.normalizeToast(toast)
+	if err != nil {
+		return nil, err
+	}
+	if data.ValidateToast(v, toast, vocabulary); !v.Valid() {
+		return nil, errFailedValidation
+	}
+	// Refuse a toast that looks like one we already have
+	if !force {
+		duplicates, err := app.models.Toasts.FindDuplicates(toast, 5)
+		if err != nil {
+			return nil, err
+		}
+		if len(duplicates) > 0 {
+			return duplicates, errDuplicateToast
+		}
+	}
+	err = app.models.Toasts.Insert(toast)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_id", "a toast with this external id already exists")
+			return nil, errFailedValidation
+		default:
+			return nil, err
+		}
+	}
+	// Look up where the toast is
+	app.geocodeToast(toast)
+	return nil, nil
+}
+
+// The updateToast() method stores the changes to a toast once it is in its
+// standard form and valid. The toast's version must still be the stored
+// one, or data.ErrEditConflict is returned. Every API edits toasts this way
+func (app *application) updateToast(toast *data.Toast, v *validator.Validator) error {
+	vocabulary, err := app.normalizeToast(toast)
+	if err != nil {
+		return err
+	}
+	if data.ValidateToast(v, toast, vocabulary); !v.Valid() {
+		return errFailedValidation
+	}
+	err = app.models.Toasts.Update(toast)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_id", "a toast with this external id already exists")
+			return errFailedValidation
+		default:
+			return err
+		}
+	}
+	// The location is cleared when the address changes
+	if toast.Latitude == nil {
+		app.geocodeToast(toast)
+	}
+	return nil
+}
+
+// The deleteToast() method removes a toast and then the stored files of its
+// attachments. The toast's version must still be the stored one, or
+// data.ErrEditConflict is returned. Every API removes toasts this way
+func (app *application) deleteToast(toast *data.Toast) error {
+	// Note the attachments, whose stored files outlive the toast's rows
+	attachments, err := app.models.Attachments.GetAllForToast(toast.ID)
+	if err != nil {
+		return err
+	}
+	err = app.models.Toasts.Delete(toast.ID, toast.Version)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, len(attachments))
+	for i, attachment := range attachments {
+		keys[i] = attachment.StorageKey
+	}
+	app.deleteStoredFiles(keys...)
+	return nil
+}
+
+// The normalizeToast() method puts the phone number, level, modes, tags and
+// opening hours of a toast in their standard forms. It returns the
+// vocabulary to validate the toast against
+func (app *application) normalizeToast(toast *data.Toast) (data.Vocabulary, error) {
+	vocabulary, err := app.vocabulary()
+	if err != nil {
+		return data.Vocabulary{}, err
+	}
+	app.normalizePhone(toast)
+	vocabulary.Canonicalize(toast)
+	// Tags are compared without case or surrounding whitespace
+	for i := range toast.Tags {
+		toast.Tags[i] = strings.ToLower(strings.TrimSpace(toast.Tags[i]))
+	}
+	if toast.Hours != nil {
+		toast.Hours.Normalize()
+	}
+	return vocabulary, nil
+}
+
+// The normalizePhone() method sets the E.164 form of a toast's phone
+// number. The number is kept as it was typed, for display. A number that
+// can't be read gets no E.164 form, for ValidateToast() to report
+func (app *application) normalizePhone(toast *data.Toast) {
+	toast.Phone = strings.TrimSpace(toast.Phone)
+	number, err := phone.Parse(toast.Phone, app.config.phone.region)
+	if err != nil {
+		toast.PhoneE164 = ""
 		return
 	}
+	toast.PhoneE164 = number.E164
+}
+
+// The geocodeToast() method looks up the location of a toast's address in
+// the background. Nothing happens when there is no geocoder
+func (app *application) geocodeToast(toast *data.Toast) {
+	if app.geocoder == nil {
+		return
+	}
+	id, address := toast.ID, toast.Address
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		location, err := app.geocoder.Geocode(ctx, address)
+		if err != nil {
+			// An address we can't find is not an error on our side
+			if !errors.Is(err, geocoder.ErrNotFound) {
+				app.logger.PrintError(err, map[string]string{"toast_id": strconv.FormatInt(id, 10)})
+			}
+			return
+		}
+		err = app.models.Toasts.SetLocation(id, address, location.Latitude, location.Longitude)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"toast_id": strconv.FormatInt(id, 10)})
+		}
+	})
 }
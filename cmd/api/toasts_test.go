@@ -0,0 +1,125 @@
+// Filename: cmd/api/toasts_test.go
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/geocoder"
+	"toaster.jalen.net/internals/jsonlog"
+)
+
+// The query SetLocation() runs. It only matches the toast while it still
+// has the address that was geocoded
+const setLocationQuery = `UPDATE toasts\s+SET latitude = \$1, longitude = \$2, updated_at = NOW\(\), version = version \+ 1\s+WHERE id = \$3\s+AND address = \$4`
+
+// The newGeocodeApp() function returns an application with a file geocoder
+// that knows one address, and a mock database
+func newGeocodeApp(t *testing.T) (*application, sqlmock.Sqlmock, *bytes.Buffer) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "locations.json")
+	err := os.WriteFile(path, []byte(`{"1 Princess Margaret Drive, Belize City": {"latitude": 17.5046, "longitude": -88.1962}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := geocoder.NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	var logs bytes.Buffer
+	app := &application{
+		logger:   jsonlog.New(&logs, jsonlog.LevelInfo),
+		models:   data.NewModels(db),
+		geocoder: g,
+	}
+	return app, mock, &logs
+}
+
+// The toastRow() function returns the row SetLocation() reads back
+func toastRow(id int64, address string, latitude, longitude float64, version int32) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{
+		"id", "created_at", "updated_at", "name", "level", "contact", "phone", "phone_e164",
+		"email", "website", "address", "mode", "tags", "attributes", "hours", "external_source",
+		"external_id", "latitude", "longitude", "created_by", "version",
+	}).AddRow(
+		id, now, now, "St. John's College", "tertiary", "Jane Doe", "+501 223-3000", "+5012233000",
+		"info@sjc.edu.bz", "https://sjc.edu.bz", address, "{face-to-face}", "{}", "{}", nil, "",
+		"", latitude, longitude, nil, version,
+	)
+}
+
+func TestGeocodeToastSetsLocation(t *testing.T) {
+	app, mock, logs := newGeocodeApp(t)
+	address := "1  princess margaret drive, belize city"
+
+	// The address is unchanged, so the location is stored with a new
+	// version and the change is recorded
+	mock.ExpectBegin()
+	mock.ExpectQuery(setLocationQuery).
+		WithArgs(17.5046, -88.1962, int64(7), address).
+		WillReturnRows(toastRow(7, address, 17.5046, -88.1962, 3))
+	mock.ExpectExec(`INSERT INTO toast_events`).
+		WithArgs(data.EventToastUpdated, int64(7), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	app.geocodeToast(&data.Toast{ID: 7, Address: address, Version: 2})
+	app.wg.Wait()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if logs.Len() > 0 {
+		t.Errorf("unexpected log output: %s", logs)
+	}
+}
+
+func TestGeocodeToastSkipsChangedAddress(t *testing.T) {
+	app, mock, logs := newGeocodeApp(t)
+	address := "1 Princess Margaret Drive, Belize City"
+
+	// The address was edited while it was being geocoded, so the update
+	// matches no row. Nothing is stored and no change is recorded
+	mock.ExpectBegin()
+	mock.ExpectQuery(setLocationQuery).
+		WithArgs(17.5046, -88.1962, int64(7), address).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	app.geocodeToast(&data.Toast{ID: 7, Address: address, Version: 2})
+	app.wg.Wait()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if logs.Len() > 0 {
+		t.Errorf("unexpected log output: %s", logs)
+	}
+}
+
+func TestGeocodeToastUnknownAddress(t *testing.T) {
+	app, mock, logs := newGeocodeApp(t)
+
+	// An address the geocoder can't find leaves the toast alone
+	app.geocodeToast(&data.Toast{ID: 7, Address: "Nowhere", Version: 2})
+	app.wg.Wait()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if logs.Len() > 0 {
+		t.Errorf("unexpected log output: %s", logs)
+	}
+}
@@ -0,0 +1,134 @@
+// Filename: cmd/api/translations.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/validator"
+)
+
+// listTranslationsHandler for the "GET /v1/toasts/:id/translations" endpoint
+func (app *application) listTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Make sure the toast exists, so a toast without translations isn't
+	// confused with a missing one
+	_, err = app.models.Toasts.GetFields(toastID, []string{"id"})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	translations, err := app.models.Translations.GetAllForToast(toastID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"translations": translations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// putTranslationHandler for the "PUT /v1/toasts/:id/translations/:locale"
+// endpoint. It creates the translation or replaces it
+func (app *application) putTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	translation := &data.Translation{
+		ToastID: toastID,
+		Locale:  app.readLocaleParam(r),
+		Name:    input.Name,
+		Address: input.Address,
+	}
+	v := validator.New()
+	// The toasts' own names are in the first locale, so it isn't translated into
+	if data.ValidateTranslation(v, translation, app.config.locales.supported[1:]); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	created, err := app.models.Translations.Put(translation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	err = app.writeJSON(w, status, envelope{"translation": translation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTranslationHandler for the "DELETE /v1/toasts/:id/translations/:locale" endpoint
+func (app *application) deleteTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	toastID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Translations.Delete(toastID, app.readLocaleParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "translation successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The translateToasts() method puts the toasts in the language the client
+// asks for and marks the response with the language it is in. Toasts are
+// already in the first supported locale, which all of them stay in when
+// any of them has no translation. It returns the language of the response
+func (app *application) translateToasts(w http.ResponseWriter, r *http.Request, toasts ...*data.Toast) (string, error) {
+	base := app.config.locales.supported[0]
+	locale := app.readLocale(r)
+	w.Header().Add("Vary", "Accept-Language")
+	if locale != base {
+		translated, err := app.models.Translations.Translate(toasts, locale)
+		if err != nil {
+			return "", err
+		}
+		if !translated {
+			locale = base
+		}
+	}
+	w.Header().Set("Content-Language", locale)
+	return locale, nil
+}
@@ -0,0 +1,86 @@
+// Filename: cmd/api/translations_test.go
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"toaster.jalen.net/internals/data"
+)
+
+// The query Translate() runs
+const translateQuery = `SELECT toast_id, name, address\s+FROM toast_translations`
+
+func TestTranslateToasts(t *testing.T) {
+	tests := []struct {
+		name         string
+		rows         *sqlmock.Rows
+		wantLanguage string
+		wantNames    []string
+		wantAddress  string
+	}{
+		{
+			name: "all translated",
+			rows: sqlmock.NewRows([]string{"toast_id", "name", "address"}).
+				AddRow(1, "Colegio San Juan", "").
+				AddRow(2, "Escuela Wesley", "Calle Albert 1"),
+			wantLanguage: "es",
+			wantNames:    []string{"Colegio San Juan", "Escuela Wesley"},
+			wantAddress:  "Calle Albert 1",
+		},
+		{
+			// One toast has no translation, so neither is translated and
+			// the list stays in one language
+			name: "mixed",
+			rows: sqlmock.NewRows([]string{"toast_id", "name", "address"}).
+				AddRow(1, "Colegio San Juan", ""),
+			wantLanguage: "en",
+			wantNames:    []string{"St. John's College", "Wesley College"},
+			wantAddress:  "1 Albert Street",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock, _ := newMockApp(t)
+			app.config.locales.supported = []string{"en", "es"}
+			mock.ExpectQuery(translateQuery).
+				WithArgs(sqlmock.AnyArg(), "es").
+				WillReturnRows(tt.rows)
+			toasts := []*data.Toast{
+				{ID: 1, Name: "St. John's College", Address: "Princess Margaret Drive"},
+				{ID: 2, Name: "Wesley College", Address: "1 Albert Street"},
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/v1/toasts", nil)
+			r.Header.Set("Accept-Language", "es")
+			locale, err := app.translateToasts(w, r, toasts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if locale != tt.wantLanguage {
+				t.Errorf("returned %q, want %q", locale, tt.wantLanguage)
+			}
+			if got := w.Header().Get("Content-Language"); got != tt.wantLanguage {
+				t.Errorf("Content-Language is %q, want %q", got, tt.wantLanguage)
+			}
+			for i, toast := range toasts {
+				if toast.Name != tt.wantNames[i] {
+					t.Errorf("toast %d is named %q, want %q", toast.ID, toast.Name, tt.wantNames[i])
+				}
+			}
+			if toasts[1].Address != tt.wantAddress {
+				t.Errorf("address is %q, want %q", toasts[1].Address, tt.wantAddress)
+			}
+			if toasts[0].Address != "Princess Margaret Drive" {
+				t.Errorf("a translation without an address changed it to %q", toasts[0].Address)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Filename: cmd/api/vocabularies.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"toaster.jalen.net/internals/data"
+	"toaster.jalen.net/internals/validator"
+)
+
+// The vocabularyCache holds the allowed levels and modes so validating a
+// toast doesn't read them every time. Other instances of the API see
+// changes once their copy expires
+type vocabularyCache struct {
+	mu         sync.RWMutex
+	vocabulary data.Vocabulary
+	expires    time.Time
+}
+
+// How long the cached vocabulary is used before it is read again
+const vocabularyTTL = time.Minute
+
+// The vocabulary() method returns the allowed levels and modes, reading
+// them from the database when the cached copy has expired
+func (app *application) vocabulary() (data.Vocabulary, error) {
+	app.vocabularies.mu.RLock()
+	vocabulary, expires := app.vocabularies.vocabulary, app.vocabularies.expires
+	app.vocabularies.mu.RUnlock()
+	if time.Now().Before(expires) {
+		return vocabulary, nil
+	}
+	vocabulary, err := app.models.Vocabularies.Get()
+	if err != nil {
+		return data.Vocabulary{}, err
+	}
+	app.vocabularies.mu.Lock()
+	app.vocabularies.vocabulary = vocabulary
+	app.vocabularies.expires = time.Now().Add(vocabularyTTL)
+	app.vocabularies.mu.Unlock()
+	return vocabulary, nil
+}
+
+// The expireVocabulary() method makes the next call to vocabulary() read
+// the levels and modes again
+func (app *application) expireVocabulary() {
+	app.vocabularies.mu.Lock()
+	app.vocabularies.expires = time.Time{}
+	app.vocabularies.mu.Unlock()
+}
+
+// listTermsHandler for the "GET /v1/toasts/levels" and "GET /v1/toasts/modes"
+// endpoints. The terms are sent under key
+func (app *application) listTermsHandler(vocabulary data.VocabularyTable, key string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		terms, err := app.models.Vocabularies.GetTerms(vocabulary)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{key: terms}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// createTermHandler for the "POST /v1/toasts/levels" and "POST /v1/toasts/modes"
+// endpoints. The term is sent back under key
+func (app *application) createTermHandler(vocabulary data.VocabularyTable, key string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name string `json:"name"`
+		}
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		v := validator.New()
+		if data.ValidateTerm(v, input.Name); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		err = app.models.Vocabularies.InsertTerm(vocabulary, input.Name)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateTerm):
+				v.AddError("name", fmt.Sprintf("this %s already exists", key))
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		app.expireVocabulary()
+		err = app.writeJSON(w, http.StatusCreated, envelope{key: input.Name}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// deleteTermHandler for the "DELETE /v1/toasts/levels/:term" and
+// "DELETE /v1/toasts/modes/:term" endpoints. Terms toasts use are kept
+func (app *application) deleteTermHandler(vocabulary data.VocabularyTable, key string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := app.models.Vocabularies.DeleteTerm(vocabulary, app.readTermParam(r))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			case errors.Is(err, data.ErrTermInUse):
+				message := fmt.Sprintf("the %s is used by toasts, change them before removing it", key)
+				app.errorResponse(w, r, http.StatusConflict, message)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		app.expireVocabulary()
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": key + " successfully deleted"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
@@ -10,8 +10,22 @@ require (
 )
 
 require (
-	golang.org/x/crypto v0.3.0
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/evanphx/json-patch v5.6.0+incompatible
+	github.com/graphql-go/graphql v0.8.1
+	github.com/nyaruka/phonenumbers v1.1.8
+	golang.org/x/crypto v0.19.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240227224415-6ceb2ff114de
+	google.golang.org/grpc v1.63.2
+	google.golang.org/protobuf v1.33.0
 	gopkg.in/mail.v2 v2.3.1
 )
 
-require gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+require (
+	github.com/golang/protobuf v1.5.4 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+)
@@ -0,0 +1,174 @@
+// Filename: internal/data/attachments.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"toaster.jalen.net/internals/validator"
+)
+
+// The content types an attachment may have, as sniffed from its contents
+var AttachmentContentTypes = []string{
+	"application/pdf",
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+	"text/plain",
+}
+
+// An Attachment is a file belonging to a toast. Its contents are kept in
+// file storage under StorageKey. URL is a short-lived download link
+type Attachment struct {
+	ID          int64     `json:"id"`
+	ToastID     int64     `json:"toast_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	StorageKey  string    `json:"-"`
+	URL         string    `json:"url,omitempty"`
+}
+
+func ValidateAttachment(v *validator.Validator, attachment *Attachment) {
+	v.Check(attachment.Filename != "", "filename", "must be provided")
+	v.Check(len(attachment.Filename) <= 255, "filename", "must not be more than 255 bytes long")
+
+	v.Check(validator.In(attachment.ContentType, AttachmentContentTypes...), "content_type", "must be a PDF, an image or plain text")
+
+	v.Check(attachment.Size > 0, "file", "must not be empty")
+}
+
+// Define an AttachmentModel which wraps a sql.DB connection pool
+type AttachmentModel struct {
+	DB *sql.DB
+}
+
+// Insert() records an attachment whose contents are already stored
+func (m AttachmentModel) Insert(attachment *Attachment) error {
+	query := `
+		INSERT INTO toast_attachments (toast_id, filename, content_type, size, storage_key)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	args := []interface{}{
+		attachment.ToastID, attachment.Filename, attachment.ContentType,
+		attachment.Size, attachment.StorageKey,
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&attachment.ID, &attachment.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "toast_attachments" violates foreign key constraint "toast_attachments_toast_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Get() returns an attachment. A toastID of zero matches any toast
+func (m AttachmentModel) Get(toastID int64, id int64) (*Attachment, error) {
+	// Ensure that there is a valid id
+	if toastID < 0 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, toast_id, created_at, filename, content_type, size, storage_key
+		FROM toast_attachments
+		WHERE ($1 = 0 OR toast_id = $1)
+		AND id = $2
+	`
+	var attachment Attachment
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, toastID, id).Scan(
+		&attachment.ID, &attachment.ToastID, &attachment.CreatedAt, &attachment.Filename,
+		&attachment.ContentType, &attachment.Size, &attachment.StorageKey,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &attachment, nil
+}
+
+// GetAllForToast() returns the attachments of a toast in the order they
+// were added
+func (m AttachmentModel) GetAllForToast(toastID int64) ([]*Attachment, error) {
+	query := `
+		SELECT id, toast_id, created_at, filename, content_type, size, storage_key
+		FROM toast_attachments
+		WHERE toast_id = $1
+		ORDER BY id ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, toastID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attachments := []*Attachment{}
+	for rows.Next() {
+		var attachment Attachment
+		err := rows.Scan(
+			&attachment.ID, &attachment.ToastID, &attachment.CreatedAt, &attachment.Filename,
+			&attachment.ContentType, &attachment.Size, &attachment.StorageKey,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, &attachment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// Delete() removes an attachment from a toast and returns the key its
+// contents are stored under, so they can be removed too
+func (m AttachmentModel) Delete(toastID int64, id int64) (string, error) {
+	// Ensure that there is a valid id
+	if toastID < 1 || id < 1 {
+		return "", ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM toast_attachments
+		WHERE toast_id = $1
+		AND id = $2
+		RETURNING storage_key
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	var key string
+	err := m.DB.QueryRowContext(ctx, query, toastID, id).Scan(&key)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return key, nil
+}
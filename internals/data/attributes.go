@@ -0,0 +1,274 @@
+// Filename: internal/data/attributes.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"toaster.jalen.net/internals/validator"
+)
+
+var (
+	ErrAttributeInUse    = errors.New("attribute in use")
+	ErrAttributeViolated = errors.New("attribute schema violated")
+)
+
+// The types an attribute value can have
+var AttributeTypes = []string{"string", "number", "boolean"}
+
+// Attribute names are used in filter expressions and SQL, so they are kept
+// to lower case letters, digits and underscores
+var AttributeNameRX = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Attributes holds the custom attributes of a toast, stored as jsonb
+type Attributes map[string]interface{}
+
+// The Value() method stores the attributes as a JSON object
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	js, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(js), nil
+}
+
+// The Scan() method reads the attributes from a jsonb column
+func (a *Attributes) Scan(src interface{}) error {
+	var js []byte
+	switch src := src.(type) {
+	case []byte:
+		js = src
+	case string:
+		js = []byte(src)
+	case nil:
+		*a = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into attributes", src)
+	}
+	return json.Unmarshal(js, a)
+}
+
+// An AttributeSchema describes a custom attribute toasts may have. Enum
+// lists the allowed values of a string attribute. None means any value
+type AttributeSchema struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Type      string    `json:"type"`
+	Required  bool      `json:"required"`
+	Enum      []string  `json:"enum,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateAttributeSchema(v *validator.Validator, schema *AttributeSchema) {
+	v.Check(schema.Name != "", "name", "must be provided")
+	v.Check(len(schema.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(schema.Name, AttributeNameRX), "name", "must start with a letter and contain only lower case letters, digits and underscores")
+
+	v.Check(validator.In(schema.Type, AttributeTypes...), "type", "must be string, number or boolean")
+
+	v.Check(len(schema.Enum) == 0 || schema.Type == "string", "enum", "is only allowed for string attributes")
+	v.Check(len(schema.Enum) <= 100, "enum", "must not contain more than 100 entries")
+	v.Check(validator.Unique(schema.Enum), "enum", "must not contain duplicate entries")
+}
+
+// The validateAttributes() function checks the attributes of a toast
+// against the schemas. Errors are reported under "attributes.<name>"
+func validateAttributes(v *validator.Validator, attributes Attributes, schemas []AttributeSchema) {
+	known := make(map[string]bool, len(schemas))
+	for _, schema := range schemas {
+		known[schema.Name] = true
+		key := "attributes." + schema.Name
+		value, ok := attributes[schema.Name]
+		if !ok || value == nil {
+			v.Check(!schema.Required, key, "must be provided")
+			continue
+		}
+		switch schema.Type {
+		case "string":
+			s, ok := value.(string)
+			v.Check(ok, key, "must be a string")
+			v.Check(len(s) <= 500, key, "must not be more than 500 bytes long")
+			v.Check(!ok || len(schema.Enum) == 0 || validator.In(s, schema.Enum...), key, "must be one of the allowed values")
+		case "number":
+			_, ok := value.(float64)
+			v.Check(ok, key, "must be a number")
+		case "boolean":
+			_, ok := value.(bool)
+			v.Check(ok, key, "must be true or false")
+		}
+	}
+	for name := range attributes {
+		v.Check(known[name], "attributes."+name, "is not a known attribute")
+	}
+}
+
+// The attributeFilterField() function describes how an attribute is used
+// in filter expressions. Values of the wrong JSON type are treated as NULL
+func attributeFilterField(schema AttributeSchema) FilterField {
+	// The name only holds characters that are safe inside a SQL string
+	value := fmt.Sprintf("attributes->>'%s'", schema.Name)
+	typeOf := fmt.Sprintf("jsonb_typeof(attributes->'%s')", schema.Name)
+	switch schema.Type {
+	case "number":
+		return FilterField{
+			Column:    fmt.Sprintf("(CASE WHEN %s = 'number' THEN (%s)::double precision END)", typeOf, value),
+			Type:      FieldNumber,
+			Operators: []string{"eq", "ne", "gt", "ge", "lt", "le"},
+		}
+	case "boolean":
+		return FilterField{
+			Column:    fmt.Sprintf("(CASE WHEN %s = 'boolean' THEN (%s)::boolean END)", typeOf, value),
+			Type:      FieldBool,
+			Operators: []string{"eq", "ne"},
+		}
+	default:
+		return FilterField{
+			Column:    fmt.Sprintf("(CASE WHEN %s = 'string' THEN %s END)", typeOf, value),
+			Type:      FieldText,
+			Operators: []string{"eq", "ne", "in", "contains"},
+		}
+	}
+}
+
+// ToastFilterFieldsFor() returns the fields of a toast that can be filtered
+// on, including its custom attributes as "attr.<name>"
+func ToastFilterFieldsFor(vocabulary Vocabulary) FilterFields {
+	fields := make(FilterFields, len(ToastFilterFields)+len(vocabulary.Attributes))
+	for name, field := range ToastFilterFields {
+		fields[name] = field
+	}
+	for _, schema := range vocabulary.Attributes {
+		fields["attr."+schema.Name] = attributeFilterField(schema)
+	}
+	return fields
+}
+
+// Define an AttributeModel which wraps a sql.DB connection pool
+type AttributeModel struct {
+	DB *sql.DB
+}
+
+// GetAll() returns the attribute schemas, sorted by name
+func (m AttributeModel) GetAll() ([]AttributeSchema, error) {
+	query := `
+		SELECT name, created_at, type, required, enum, version
+		FROM toast_attributes
+		ORDER BY name ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	schemas := []AttributeSchema{}
+	for rows.Next() {
+		var schema AttributeSchema
+		err := rows.Scan(&schema.Name, &schema.CreatedAt, &schema.Type, &schema.Required,
+			pq.Array(&schema.Enum), &schema.Version)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// Put() creates an attribute schema or replaces the one with the same name.
+// It reports whether a new schema was created. A schema the stored toasts
+// don't all meet is not saved: Put() returns ErrAttributeViolated and the
+// number of toasts that break it, so old data never falls outside the schema
+func (m AttributeModel) Put(schema *AttributeSchema) (bool, int, error) {
+	query := `
+		WITH violating AS (
+			SELECT COUNT(*) AS toasts
+			FROM toasts
+			WHERE (COALESCE(jsonb_typeof(attributes->$1::text), 'null') = 'null' AND $3::boolean)
+			OR jsonb_typeof(attributes->$1::text) NOT IN ('null', $2::text)
+			OR (jsonb_typeof(attributes->$1::text) = 'string'
+			    AND cardinality(COALESCE($4::text[], '{}')) > 0
+			    AND NOT attributes->>$1::text = ANY(COALESCE($4::text[], '{}')))
+		), put AS (
+			INSERT INTO toast_attributes (name, type, required, enum)
+			SELECT $1::text, $2::text, $3::boolean, COALESCE($4::text[], '{}')
+			WHERE (SELECT toasts FROM violating) = 0
+			ON CONFLICT (name)
+			DO UPDATE SET type = EXCLUDED.type, required = EXCLUDED.required,
+			    enum = EXCLUDED.enum, version = toast_attributes.version + 1
+			RETURNING created_at, version, xmax = 0 AS created
+		)
+		SELECT violating.toasts, COALESCE(put.created_at, NOW()), COALESCE(put.version, 0),
+		       COALESCE(put.created, FALSE)
+		FROM violating LEFT JOIN put ON TRUE
+	`
+	args := []interface{}{schema.Name, schema.Type, schema.Required, pq.Array(schema.Enum)}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	// The xmax system column is only zero for a freshly inserted row
+	var violating int
+	var created bool
+	var createdAt time.Time
+	var version int32
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&violating, &createdAt, &version, &created)
+	if err != nil {
+		return false, 0, err
+	}
+	if violating > 0 {
+		return false, violating, ErrAttributeViolated
+	}
+	schema.CreatedAt, schema.Version = createdAt, version
+	return created, 0, nil
+}
+
+// Delete() removes an attribute schema. A schema that toasts still have a
+// value for can't be removed
+func (m AttributeModel) Delete(name string) error {
+	query := `
+		WITH used AS (
+			SELECT EXISTS (SELECT 1 FROM toasts WHERE attributes ? $1) AS used
+		), deleted AS (
+			DELETE FROM toast_attributes
+			WHERE name = $1
+			AND NOT (SELECT used FROM used)
+			RETURNING name
+		)
+		SELECT (SELECT used FROM used), EXISTS (SELECT 1 FROM toast_attributes WHERE name = $1)
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	// The final SELECT sees the table as it was before the delete
+	var used, exists bool
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(&used, &exists)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !exists:
+		return ErrRecordNotFound
+	case used:
+		return ErrAttributeInUse
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Filename: internal/data/attributes_test.go
+
+package data
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// The query Put() runs
+const putAttributeQuery = `WITH violating AS \(\s+SELECT COUNT\(\*\) AS toasts\s+FROM toasts`
+
+func TestAttributePut(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		row           []driver.Value
+		wantCreated   bool
+		wantViolating int
+		wantErr       error
+		wantVersion   int32
+	}{
+		{
+			name:        "created",
+			row:         []driver.Value{0, now, 1, true},
+			wantCreated: true,
+			wantVersion: 1,
+		},
+		{
+			name:        "replaced",
+			row:         []driver.Value{0, now, 4, false},
+			wantVersion: 4,
+		},
+		{
+			// Nothing is saved, so the schema keeps the version it was sent with
+			name:          "stored toasts break it",
+			row:           []driver.Value{3, now, 0, false},
+			wantViolating: 3,
+			wantErr:       ErrAttributeViolated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			models := NewModels(db)
+			mock.ExpectQuery(putAttributeQuery).
+				WithArgs("level_of_care", "string", true, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"toasts", "created_at", "version", "created"}).AddRow(tt.row...))
+
+			schema := &AttributeSchema{Name: "level_of_care", Type: "string", Required: true, Enum: []string{"day", "boarding"}}
+			created, violating, err := models.Attributes.Put(schema)
+			if err != tt.wantErr {
+				t.Fatalf("Put() returned %v, want %v", err, tt.wantErr)
+			}
+			if created != tt.wantCreated || violating != tt.wantViolating {
+				t.Errorf("got created %t, %d violating, want %t, %d", created, violating, tt.wantCreated, tt.wantViolating)
+			}
+			if schema.Version != tt.wantVersion {
+				t.Errorf("schema is at version %d, want %d", schema.Version, tt.wantVersion)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
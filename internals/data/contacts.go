@@ -0,0 +1,329 @@
+// Filename: internal/data/contacts.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"toaster.jalen.net/internals/validator"
+)
+
+var (
+	ErrPrimaryContact = errors.New("primary contact")
+)
+
+// A Contact is one of the people to reach at a toast. Each toast has one
+// primary contact, whose name, phone and email are also the toast's
+// contact, phone and email. The two are always written together
+type Contact struct {
+	ID        int64     `json:"id"`
+	ToastID   int64     `json:"toast_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"`
+	Phone     string    `json:"phone,omitempty"`
+	PhoneE164 string    `json:"phone_e164,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Primary   bool      `json:"primary"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateContact(v *validator.Validator, contact *Contact) {
+	v.Check(contact.Name != "", "name", "must be provided")
+	v.Check(len(contact.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(contact.Role) <= 200, "role", "must not be more than 200 bytes long")
+
+	// A contact needs some way of reaching them
+	v.Check(contact.Phone != "" || contact.Email != "", "phone", "must be provided if there is no email")
+	v.Check(len(contact.Phone) <= 50, "phone", "must not be more than 50 bytes long")
+	v.Check(contact.Phone == "" || contact.PhoneE164 != "", "phone", "must be a valid phone number")
+
+	v.Check(contact.Email == "" || validator.Matches(contact.Email, validator.EmailRX), "email", "must be a valid email address")
+
+	// The primary contact's details are the toast's, which needs them all
+	if contact.Primary {
+		v.Check(contact.Phone != "", "phone", "must be provided for the primary contact")
+		v.Check(contact.Email != "", "email", "must be provided for the primary contact")
+	}
+}
+
+// Define a ContactModel which wraps a sql.DB connection pool
+type ContactModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a contact to a toast. A new primary contact takes over from
+// the toast's previous one, and its details become the toast's
+func (m ContactModel) Insert(contact *Contact) error {
+	query := `
+		INSERT INTO toast_contacts (toast_id, name, role, phone, phone_e164, email, is_primary)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, version
+	`
+	args := []interface{}{
+		contact.ToastID, contact.Name, contact.Role,
+		contact.Phone, contact.PhoneE164, contact.Email,
+		contact.Primary,
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if contact.Primary {
+		// The toast changes too, so it is locked before anything is written
+		err = lockToasts(ctx, tx, contact.ToastID)
+		if err != nil {
+			return err
+		}
+		err = m.clearPrimary(ctx, tx, contact.ToastID, 0)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&contact.ID, &contact.CreatedAt, &contact.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "toast_contacts" violates foreign key constraint "toast_contacts_toast_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if contact.Primary {
+		err = m.setToastContact(ctx, tx, contact)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Get() returns a contact of a toast
+func (m ContactModel) Get(toastID int64, id int64) (*Contact, error) {
+	// Ensure that there is a valid id
+	if toastID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, toast_id, created_at, name, role, phone, phone_e164, email, is_primary, version
+		FROM toast_contacts
+		WHERE toast_id = $1
+		AND id = $2
+	`
+	var contact Contact
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, toastID, id).Scan(
+		&contact.ID, &contact.ToastID, &contact.CreatedAt, &contact.Name, &contact.Role,
+		&contact.Phone, &contact.PhoneE164, &contact.Email, &contact.Primary, &contact.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &contact, nil
+}
+
+// GetAllForToast() returns the contacts of a toast, the primary contact
+// first and then in the order they were added
+func (m ContactModel) GetAllForToast(toastID int64) ([]*Contact, error) {
+	query := `
+		SELECT id, toast_id, created_at, name, role, phone, phone_e164, email, is_primary, version
+		FROM toast_contacts
+		WHERE toast_id = $1
+		ORDER BY is_primary DESC, id ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, toastID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	contacts := []*Contact{}
+	for rows.Next() {
+		var contact Contact
+		err := rows.Scan(
+			&contact.ID, &contact.ToastID, &contact.CreatedAt, &contact.Name, &contact.Role,
+			&contact.Phone, &contact.PhoneE164, &contact.Email, &contact.Primary, &contact.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, &contact)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+// Update() edits a contact. Making it the primary contact takes over from
+// the toast's previous one, and the primary contact's details are the
+// toast's
+// Optimistic locking (version number)
+func (m ContactModel) Update(contact *Contact) error {
+	query := `
+		UPDATE toast_contacts
+		SET name = $1, role = $2, phone = $3, phone_e164 = $4, email = $5,
+		    is_primary = $6, version = version + 1
+		WHERE toast_id = $7
+		AND id = $8
+		AND version = $9
+		RETURNING version
+	`
+	args := []interface{}{
+		contact.Name, contact.Role, contact.Phone, contact.PhoneE164, contact.Email,
+		contact.Primary, contact.ToastID, contact.ID, contact.Version,
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if contact.Primary {
+		// The toast changes too, so it is locked before anything is written
+		err = lockToasts(ctx, tx, contact.ToastID)
+		if err != nil {
+			return err
+		}
+		err = m.clearPrimary(ctx, tx, contact.ToastID, contact.ID)
+		if err != nil {
+			return err
+		}
+	}
+	// Check for edit conflicts
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&contact.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	if contact.Primary {
+		err = m.setToastContact(ctx, tx, contact)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// The setToastContact() method copies the details of a toast's primary
+// contact to the toast and records the change
+func (m ContactModel) setToastContact(ctx context.Context, tx *sql.Tx, contact *Contact) error {
+	columns, scan := toastSelection(nil)
+	query := fmt.Sprintf(`
+		UPDATE toasts
+		SET contact = $1, phone = $2, phone_e164 = $3, email = $4,
+		    updated_at = NOW(), version = version + 1
+		WHERE id = $5
+		RETURNING %s
+	`, columns)
+	var toast Toast
+	err := tx.QueryRowContext(ctx, query, contact.Name, contact.Phone, contact.PhoneE164, contact.Email,
+		contact.ToastID).Scan(scan(&toast)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return recordEvent(ctx, tx, EventToastUpdated, &toast)
+}
+
+// The setPrimaryContact() function copies the contact details of a toast to
+// its primary contact, adding one if it has none. It runs in the
+// transaction that writes the toast
+func setPrimaryContact(ctx context.Context, q queryer, toast *Toast) error {
+	query := `
+		WITH updated AS (
+			UPDATE toast_contacts
+			SET name = $2, phone = $3, phone_e164 = $4, email = $5, version = version + 1
+			WHERE toast_id = $1
+			AND is_primary
+			AND (name, phone, phone_e164, email) IS DISTINCT FROM ($2, $3, $4, $5)
+		)
+		INSERT INTO toast_contacts (toast_id, name, phone, phone_e164, email, is_primary)
+		SELECT $1, $2, $3, $4, $5, TRUE
+		WHERE NOT EXISTS (SELECT 1 FROM toast_contacts WHERE toast_id = $1 AND is_primary)
+	`
+	_, err := q.ExecContext(ctx, query, toast.ID, toast.Contact, toast.Phone, toast.PhoneE164, toast.Email)
+	return err
+}
+
+// The clearPrimary() method makes the primary contact of a toast, other
+// than the contact with id except, an ordinary contact
+func (m ContactModel) clearPrimary(ctx context.Context, tx *sql.Tx, toastID int64, except int64) error {
+	query := `
+		UPDATE toast_contacts
+		SET is_primary = FALSE, version = version + 1
+		WHERE toast_id = $1
+		AND id <> $2
+		AND is_primary
+	`
+	_, err := tx.ExecContext(ctx, query, toastID, except)
+	return err
+}
+
+// Delete() removes a contact from a toast. The primary contact can't be
+// removed, as the toast's contact details are its
+func (m ContactModel) Delete(toastID int64, id int64) error {
+	// Ensure that there is a valid id
+	if toastID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM toast_contacts
+		WHERE toast_id = $1
+		AND id = $2
+		AND NOT is_primary
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, toastID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// Tell a primary contact apart from a missing one
+	if rowsAffected == 0 {
+		_, err := m.Get(toastID, id)
+		if err != nil {
+			return err
+		}
+		return ErrPrimaryContact
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+// Filename: internal/data/duplicates.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"toaster.jalen.net/internals/validator"
+)
+
+// A Duplicate is a toast that looks like the same institution as another.
+// The reasons say which of name, phone, email and website match
+type Duplicate struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Similarity float64  `json:"similarity"`
+	Reasons    []string `json:"reasons"`
+}
+
+// A DuplicatePair is two stored toasts that look like the same institution
+type DuplicatePair struct {
+	Toast     Duplicate `json:"toast"`
+	Duplicate Duplicate `json:"duplicate"`
+}
+
+// The reasons toasts a and b look like the same institution. Names match
+// when their trigram similarity is at least 0.6, and websites when they
+// are on the same domain
+const duplicateReasons = `array_remove(ARRAY[
+	CASE WHEN similarity(a.name, b.name) >= 0.6 THEN 'name' END,
+	CASE WHEN a.phone_e164 <> '' AND a.phone_e164 = b.phone_e164 THEN 'phone' END,
+	CASE WHEN a.email <> '' AND lower(a.email) = lower(b.email) THEN 'email' END,
+	CASE WHEN website_domain(a.website) = website_domain(b.website) THEN 'website' END
+], NULL)`
+
+// A looser test than duplicateReasons that the indexes can answer, used to
+// pick the rows worth comparing. The % operator uses the default trigram
+// similarity threshold of 0.3
+const duplicateCandidates = `(b.name % a.name
+	OR (a.phone_e164 <> '' AND b.phone_e164 = a.phone_e164)
+	OR (a.email <> '' AND lower(b.email) = lower(a.email))
+	OR website_domain(b.website) = website_domain(a.website))`
+
+// FindDuplicates() returns up to limit stored toasts that look like the
+// same institution as toast, the most likely first. The toast itself is
+// left out if it is stored
+func (m ToastModel) FindDuplicates(toast *Toast, limit int) ([]*Duplicate, error) {
+	query := fmt.Sprintf(`
+		SELECT id, name, similarity, reasons
+		FROM (
+			SELECT b.id, b.name, similarity(a.name, b.name) AS similarity, %s AS reasons
+			FROM (SELECT $1::text AS name, $2::text AS phone_e164, $3::text AS email,
+			             $4::text AS website) AS a, toasts AS b
+			WHERE %s
+			AND b.id <> $5
+		) AS candidates
+		WHERE cardinality(reasons) > 0
+		ORDER BY cardinality(reasons) DESC, similarity DESC, id ASC
+		LIMIT $6
+	`, duplicateReasons, duplicateCandidates)
+	args := []interface{}{toast.Name, toast.PhoneE164, toast.Email, toast.Website, toast.ID, limit}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	duplicates := []*Duplicate{}
+	for rows.Next() {
+		var duplicate Duplicate
+		err := rows.Scan(&duplicate.ID, &duplicate.Name, &duplicate.Similarity, pq.Array(&duplicate.Reasons))
+		if err != nil {
+			return nil, err
+		}
+		duplicates = append(duplicates, &duplicate)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return duplicates, nil
+}
+
+// The pairs of stored toasts worth comparing, as duplicateCandidates picks
+// them. Each test is its own join so it can use its index: one join on all
+// four at once can't, and compares every pair of toasts
+const duplicatePairs = `
+	SELECT a.id AS toast_id, b.id AS duplicate_id
+	FROM toasts AS a INNER JOIN toasts AS b ON b.name % a.name
+	WHERE a.id < b.id
+	UNION
+	SELECT a.id, b.id
+	FROM toasts AS a INNER JOIN toasts AS b ON b.phone_e164 = a.phone_e164
+	WHERE a.id < b.id AND a.phone_e164 <> ''
+	UNION
+	SELECT a.id, b.id
+	FROM toasts AS a INNER JOIN toasts AS b ON lower(b.email) = lower(a.email)
+	WHERE a.id < b.id AND a.email <> ''
+	UNION
+	SELECT a.id, b.id
+	FROM toasts AS a INNER JOIN toasts AS b ON website_domain(b.website) = website_domain(a.website)
+	WHERE a.id < b.id`
+
+// GetDuplicates() returns a page of the pairs of stored toasts that look
+// like the same institution, the most likely first
+func (m ToastModel) GetDuplicates(filters Filters) ([]*DuplicatePair, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), toast_id, toast_name, duplicate_id, duplicate_name, similarity, reasons
+		FROM (
+			SELECT a.id AS toast_id, a.name AS toast_name, b.id AS duplicate_id, b.name AS duplicate_name,
+			       similarity(a.name, b.name) AS similarity, %s AS reasons
+			FROM (%s) AS candidates
+			INNER JOIN toasts AS a ON a.id = candidates.toast_id
+			INNER JOIN toasts AS b ON b.id = candidates.duplicate_id
+		) AS pairs
+		WHERE cardinality(reasons) > 0
+		ORDER BY cardinality(reasons) DESC, similarity DESC, toast_id ASC, duplicate_id ASC
+		LIMIT $1 OFFSET $2
+	`, duplicateReasons, duplicatePairs)
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	pairs := []*DuplicatePair{}
+	for rows.Next() {
+		var pair DuplicatePair
+		var reasons []string
+		err := rows.Scan(&totalRecords, &pair.Toast.ID, &pair.Toast.Name, &pair.Duplicate.ID,
+			&pair.Duplicate.Name, &pair.Duplicate.Similarity, pq.Array(&reasons))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		// The similarity and reasons describe the pair, so both sides get them
+		pair.Toast.Similarity, pair.Toast.Reasons = pair.Duplicate.Similarity, reasons
+		pair.Duplicate.Reasons = reasons
+		pairs = append(pairs, &pair)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return pairs, metadata, nil
+}
+
+// Merge() combines the toast with id duplicateID into toast and removes it.
+// The toast keeps its own details, gains the modes it lacks (up to five),
+// takes over the external id if it has none, and takes on the duplicate's
+// contacts as ordinary contacts, its attachments and the translations it
+// has no translation of its own for
+// Optimistic locking (version number)
+func (m ToastModel) Merge(toast *Toast, duplicateID int64) error {
+	if duplicateID < 1 || duplicateID == toast.ID {
+		return ErrRecordNotFound
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Lock both toasts before anything is written, so the events come in
+	// the order of the changes
+	// A missing toast is reported below, as a missing duplicate or an edit
+	// conflict
+	err = lockToasts(ctx, tx, toast.ID, duplicateID)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+	// Read the duplicate while we combine it
+	var duplicate Toast
+	err = tx.QueryRowContext(ctx, `
+		SELECT mode, external_source, external_id
+		FROM toasts
+		WHERE id = $1
+		FOR UPDATE
+	`, duplicateID).Scan(pq.Array(&duplicate.Mode), &duplicate.ExternalSource, &duplicate.ExternalID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	for _, mode := range duplicate.Mode {
+		if len(toast.Mode) < 5 && !validator.In(mode, toast.Mode...) {
+			toast.Mode = append(toast.Mode, mode)
+		}
+	}
+	if toast.ExternalID == "" {
+		toast.ExternalSource, toast.ExternalID = duplicate.ExternalSource, duplicate.ExternalID
+	}
+	// Move the contacts, attachments and translations across before the duplicate's removal takes them
+	// with it
+	_, err = tx.ExecContext(ctx, `
+		UPDATE toast_contacts
+		SET toast_id = $1, is_primary = FALSE, version = version + 1
+		WHERE toast_id = $2
+	`, toast.ID, duplicateID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE toast_attachments
+		SET toast_id = $1
+		WHERE toast_id = $2
+	`, toast.ID, duplicateID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE toast_translations
+		SET toast_id = $1
+		WHERE toast_id = $2
+		AND locale NOT IN (SELECT locale FROM toast_translations WHERE toast_id = $1)
+	`, toast.ID, duplicateID)
+	if err != nil {
+		return err
+	}
+	// Remove the duplicate first so its external id is free to take over
+	err = m.delete(ctx, tx, duplicateID, 0)
+	if err != nil {
+		return err
+	}
+	err = m.update(ctx, tx, toast)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
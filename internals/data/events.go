@@ -0,0 +1,280 @@
+// Filename: internal/data/events.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The kinds of change to a toast
+const (
+	EventToastCreated = "toast.created"
+	EventToastUpdated = "toast.updated"
+	EventToastDeleted = "toast.deleted"
+)
+
+var EventTypes = []string{EventToastCreated, EventToastUpdated, EventToastDeleted}
+
+// An Event records a change to a toast. Toast is the toast as it was after
+// the change, or before its removal
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	ToastID   int64           `json:"toast_id"`
+	Toast     json.RawMessage `json:"toast"`
+	// Where the event is in the sequence GetAfter() reads
+	Position EventPosition `json:"-"`
+}
+
+// An EventPosition is a place in the sequence of events: the transaction
+// that wrote an event, then its id. Ids alone are taken when an event is
+// written, not when it commits, so a later id can be seen first
+type EventPosition struct {
+	TxID int64 `json:"tx"`
+	ID   int64 `json:"id"`
+}
+
+// The recordEvent() function stores a change to a toast and queues its
+// delivery to the webhooks that want it. It runs in the transaction that
+// makes the change, so an event is stored exactly when its change is. The
+// event's txid column defaults to that transaction
+func recordEvent(ctx context.Context, q queryer, eventType string, toast *Toast) error {
+	payload, err := json.Marshal(toast)
+	if err != nil {
+		return err
+	}
+	query := `
+		WITH event AS (
+			INSERT INTO toast_events (type, toast_id, payload)
+			VALUES ($1, $2, $3)
+			RETURNING id
+		)
+		INSERT INTO webhook_deliveries (webhook_id, event_id)
+		SELECT webhooks.id, event.id
+		FROM webhooks, event
+		WHERE webhooks.active
+		AND (webhooks.events = '{}' OR $1 = ANY(webhooks.events))
+	`
+	_, err = q.ExecContext(ctx, query, eventType, toast.ID, string(payload))
+	return err
+}
+
+// The lockToasts() function locks the rows of the toasts with the ids, in
+// id order so transactions can't deadlock over them. A transaction gets its
+// txid from its first write, and waits for the ones ahead of it at the
+// first toast row it locks, so that must be the same statement: a
+// transaction that writes other rows before recording an event locks the
+// toast first. Its events then come after those of the changes to the
+// toast it waited for
+func lockToasts(ctx context.Context, q queryer, ids ...int64) error {
+	query := `
+		SELECT COUNT(*)
+		FROM (SELECT id FROM toasts WHERE id = ANY($1) ORDER BY id FOR UPDATE) AS locked
+	`
+	var locked int
+	err := q.QueryRowContext(ctx, query, pq.Array(ids)).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if locked < len(ids) {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The EncodeChangeToken() function turns the position of the last event a
+// client has seen into an opaque token
+func EncodeChangeToken(position EventPosition) string {
+	js, err := json.Marshal(position)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// The DecodeChangeToken() function reads a token created by
+// EncodeChangeToken(). The empty token is the start of the sequence
+func DecodeChangeToken(s string) (EventPosition, error) {
+	if s == "" {
+		return EventPosition{}, nil
+	}
+	var position EventPosition
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return EventPosition{}, err
+	}
+	err = json.Unmarshal(js, &position)
+	if err != nil {
+		return EventPosition{}, err
+	}
+	if position.TxID < 0 || position.ID < 0 {
+		return EventPosition{}, errors.New("invalid change token")
+	}
+	return position, nil
+}
+
+// Define an EventModel which wraps a sql.DB connection pool
+type EventModel struct {
+	DB *sql.DB
+}
+
+// Latest() returns the position GetAfter() reads new events from. Events
+// that turn up from then on come after it
+func (m EventModel) Latest() (EventPosition, error) {
+	query := `
+		SELECT txid_snapshot_xmin(txid_current_snapshot())
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	var position EventPosition
+	err := m.DB.QueryRowContext(ctx, query).Scan(&position.TxID)
+	return position, err
+}
+
+// GetAfter() returns up to limit events that came after the position,
+// oldest first. Only the events of transactions older than any still
+// running are read: the ones before them can't turn up later. A long
+// running write holds the newer events back until it ends
+func (m EventModel) GetAfter(after EventPosition, limit int) ([]*Event, error) {
+	query := `
+		SELECT id, txid, type, created_at, toast_id, payload
+		FROM toast_events
+		WHERE (txid, id) > ($1, $2)
+		AND txid < txid_snapshot_xmin(txid_current_snapshot())
+		ORDER BY txid ASC, id ASC
+		LIMIT $3
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, after.TxID, after.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*Event{}
+	for rows.Next() {
+		var event Event
+		var payload []byte
+		err := rows.Scan(&event.ID, &event.Position.TxID, &event.Type, &event.CreatedAt, &event.ToastID, &payload)
+		if err != nil {
+			return nil, err
+		}
+		event.Position.ID = event.ID
+		event.Toast = payload
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetForToast() returns up to limit of the changes to a toast, newest first
+func (m EventModel) GetForToast(toastID int64, limit int) ([]*Event, error) {
+	query := `
+		SELECT id, type, created_at, toast_id, payload
+		FROM toast_events
+		WHERE toast_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, toastID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*Event{}
+	for rows.Next() {
+		var event Event
+		var payload []byte
+		err := rows.Scan(&event.ID, &event.Type, &event.CreatedAt, &event.ToastID, &payload)
+		if err != nil {
+			return nil, err
+		}
+		event.Toast = payload
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// RecordExisting() records a toast.created event for up to limit toasts
+// that have none, such as the toasts stored before events were, so the
+// events hold every toast. Webhooks are not sent these events. It returns
+// how many were recorded
+func (m EventModel) RecordExisting(limit int) (int, error) {
+	columns, scan := toastSelection(nil)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM toasts
+		WHERE NOT EXISTS (SELECT 1 FROM toast_events WHERE toast_events.toast_id = toasts.id)
+		ORDER BY id ASC
+		LIMIT $1
+		FOR UPDATE
+	`, columns)
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// The toasts are locked so a change to one can't record its event
+	// while we record its creation
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	toasts := []*Toast{}
+	for rows.Next() {
+		var toast Toast
+		err := rows.Scan(scan(&toast)...)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		toasts = append(toasts, &toast)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(toasts) == 0 {
+		return 0, nil
+	}
+	for _, toast := range toasts {
+		payload, err := json.Marshal(toast)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO toast_events (type, toast_id, payload)
+			VALUES ($1, $2, $3)
+		`, EventToastCreated, toast.ID, string(payload))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(toasts), tx.Commit()
+}
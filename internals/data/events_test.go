@@ -0,0 +1,128 @@
+// Filename: internal/data/events_test.go
+
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// The query lockToasts() runs
+const lockToastsQuery = `SELECT COUNT\(\*\)\s+FROM \(SELECT id FROM toasts WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE\)`
+
+// An error that ends a test part way through a transaction
+var errTestStop = errors.New("stop")
+
+// The toastRow() function returns a stored toast as the database sends it
+func toastRow(id int64, version int32) *sqlmock.Rows {
+	columns := make([]string, len(toastColumns))
+	for i, c := range toastColumns {
+		columns[i] = c.column
+	}
+	now := time.Now()
+	return sqlmock.NewRows(columns).AddRow(
+		id, now, now, "St. John's College", "tertiary", "Jane Doe", "+501 223-3000", "+5012233000",
+		"info@sjc.edu.bz", "https://sjc.edu.bz", "1 Princess Margaret Drive", "{face-to-face}", "{}", "{}", nil, "",
+		"", nil, nil, nil, version,
+	)
+}
+
+// A transaction gets its txid from its first write. A primary contact's
+// change records a toast.updated event, so the toast is locked before the
+// contacts are written, or the event could sort before one for a change
+// to the toast that committed first
+func TestContactUpdateLocksToastFirst(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	models := NewModels(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockToastsQuery).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec(`UPDATE toast_contacts\s+SET is_primary = FALSE`).
+		WithArgs(int64(7), int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE toast_contacts\s+SET name = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	mock.ExpectQuery(`UPDATE toasts\s+SET contact = \$1`).
+		WillReturnRows(toastRow(7, 5))
+	mock.ExpectExec(`INSERT INTO toast_events`).
+		WithArgs(EventToastUpdated, int64(7), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = models.Contacts.Update(&Contact{
+		ID: 3, ToastID: 7, Name: "Jane Doe", Phone: "+501 223-3000",
+		Email: "info@sjc.edu.bz", Primary: true, Version: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContactInsertLocksToastFirst(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	models := NewModels(db)
+
+	// The toast is gone, so nothing is written
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockToastsQuery).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectRollback()
+
+	err = models.Contacts.Insert(&Contact{
+		ToastID: 7, Name: "Jane Doe", Phone: "+501 223-3000",
+		Email: "info@sjc.edu.bz", Primary: true,
+	})
+	if err != ErrRecordNotFound {
+		t.Errorf("Insert() returned %v, want ErrRecordNotFound", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeLocksToastsFirst(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	models := NewModels(db)
+
+	// Both toasts are locked before the duplicate's contacts move
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockToastsQuery).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`SELECT mode, external_source, external_id\s+FROM toasts`).
+		WithArgs(int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"mode", "external_source", "external_id"}).AddRow("{online}", "", ""))
+	mock.ExpectExec(`UPDATE toast_contacts\s+SET toast_id = \$1`).
+		WithArgs(int64(7), int64(9)).
+		WillReturnError(errTestStop)
+	mock.ExpectRollback()
+
+	err = models.Toasts.Merge(&Toast{ID: 7, Version: 4, Mode: []string{"face-to-face"}}, 9)
+	if err != errTestStop {
+		t.Errorf("Merge() returned %v, want the error that stopped it", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
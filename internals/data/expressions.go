@@ -0,0 +1,451 @@
+// Filename: internal/data/expressions.go
+
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// The types a filter field can have. The type decides how values are
+// converted and which SQL is generated for an operator
+type FieldType int
+
+const (
+	FieldText FieldType = iota
+	FieldInteger
+	FieldTime
+	FieldBool
+	FieldTextArray
+	FieldNumber
+)
+
+// A FilterField describes a field that may be used in a filter expression
+// and the operators that are allowed on it
+type FilterField struct {
+	Column    string
+	Type      FieldType
+	Operators []string
+}
+
+// FilterFields is the allow-list of fields for a resource, keyed by the
+// name clients use in the filter expression
+type FilterFields map[string]FilterField
+
+// The fields of a toast that can be filtered on
+var ToastFilterFields = FilterFields{
+	"id":         {Column: "id", Type: FieldInteger, Operators: []string{"eq", "ne", "gt", "ge", "lt", "le", "in"}},
+	"name":       {Column: "name", Type: FieldText, Operators: []string{"eq", "ne", "in", "contains"}},
+	"level":      {Column: "level", Type: FieldText, Operators: []string{"eq", "ne", "in", "contains"}},
+	"contact":    {Column: "contact", Type: FieldText, Operators: []string{"eq", "ne", "contains"}},
+	"phone":      {Column: "phone", Type: FieldText, Operators: []string{"eq", "ne", "contains"}},
+	"phone_e164": {Column: "phone_e164", Type: FieldText, Operators: []string{"eq", "ne"}},
+	"email":      {Column: "email", Type: FieldText, Operators: []string{"eq", "ne", "contains"}},
+	"website":    {Column: "website", Type: FieldText, Operators: []string{"eq", "ne", "contains"}},
+	"address":    {Column: "address", Type: FieldText, Operators: []string{"eq", "ne", "contains"}},
+	"mode":       {Column: "mode", Type: FieldTextArray, Operators: []string{"eq", "ne", "in"}},
+	"tag":        {Column: "tags", Type: FieldTextArray, Operators: []string{"eq", "ne", "in"}},
+	"created_at": {Column: "created_at", Type: FieldTime, Operators: []string{"eq", "ne", "gt", "ge", "lt", "le"}},
+	"updated_at": {Column: "updated_at", Type: FieldTime, Operators: []string{"eq", "ne", "gt", "ge", "lt", "le"}},
+	"version":    {Column: "version", Type: FieldInteger, Operators: []string{"eq", "ne", "gt", "ge", "lt", "le"}},
+}
+
+// The fields of a user that can be filtered on
+var UserFilterFields = FilterFields{
+	"id":         {Column: "id", Type: FieldInteger, Operators: []string{"eq", "ne", "gt", "ge", "lt", "le", "in"}},
+	"name":       {Column: "name", Type: FieldText, Operators: []string{"eq", "ne", "in", "contains"}},
+	"email":      {Column: "email", Type: FieldText, Operators: []string{"eq", "ne", "in", "contains"}},
+	"activated":  {Column: "activated", Type: FieldBool, Operators: []string{"eq", "ne"}},
+	"created_at": {Column: "created_at", Type: FieldTime, Operators: []string{"eq", "ne", "gt", "ge", "lt", "le"}},
+}
+
+// Limits that keep filter expressions cheap to parse and to run
+const (
+	maxFilterLength = 1000
+	maxFilterDepth  = 10
+)
+
+// A filterNode is a node of a parsed filter expression. The sql() method
+// renders the node and adds its values to args. Placeholders are numbered
+// from n
+type filterNode interface {
+	sql(n int, args *[]interface{}) string
+}
+
+// A filterLogic node joins its operands with AND or OR
+type filterLogic struct {
+	operator string
+	operands []filterNode
+}
+
+func (f filterLogic) sql(n int, args *[]interface{}) string {
+	parts := make([]string, len(f.operands))
+	for i, operand := range f.operands {
+		parts[i] = operand.sql(n, args)
+	}
+	return "(" + strings.Join(parts, " "+f.operator+" ") + ")"
+}
+
+// A filterNot node negates its operand
+type filterNot struct {
+	operand filterNode
+}
+
+func (f filterNot) sql(n int, args *[]interface{}) string {
+	return "NOT " + f.operand.sql(n, args)
+}
+
+// A filterComparison node compares a field with one or more values
+type filterComparison struct {
+	field    FilterField
+	operator string
+	values   []interface{}
+}
+
+func (f filterComparison) sql(n int, args *[]interface{}) string {
+	// Add a value to the arguments and return its placeholder
+	placeholder := func(value interface{}) string {
+		*args = append(*args, value)
+		return fmt.Sprintf("$%d", n+len(*args)-1)
+	}
+	column := f.field.Column
+	// Array columns are checked for the values they hold, with the
+	// operators their GIN indexes answer
+	if f.field.Type == FieldTextArray {
+		switch f.operator {
+		case "eq":
+			return fmt.Sprintf("(%s @> ARRAY[%s]::text[])", column, placeholder(f.values[0]))
+		case "ne":
+			return fmt.Sprintf("(NOT %s @> ARRAY[%s]::text[])", column, placeholder(f.values[0]))
+		case "in":
+			return fmt.Sprintf("(%s && %s)", column, placeholder(f.array()))
+		}
+	}
+	switch f.operator {
+	case "in":
+		return fmt.Sprintf("(%s = ANY(%s))", column, placeholder(f.array()))
+	case "contains":
+		pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.values[0].(string))
+		return fmt.Sprintf("(%s ILIKE %s)", column, placeholder("%"+pattern+"%"))
+	}
+	operators := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+	return fmt.Sprintf("(%s %s %s)", column, operators[f.operator], placeholder(f.values[0]))
+}
+
+// The array() method converts the values of an "in" comparison to a
+// postgres array of the field's type
+func (f filterComparison) array() interface{} {
+	if f.field.Type == FieldInteger {
+		array := make(pq.Int64Array, len(f.values))
+		for i := range f.values {
+			array[i] = f.values[i].(int64)
+		}
+		return array
+	}
+	array := make(pq.StringArray, len(f.values))
+	for i := range f.values {
+		array[i] = f.values[i].(string)
+	}
+	return array
+}
+
+// The symbols that can be written instead of the comparison operators, as
+// in `attr.capacity>=100`
+var symbolOperators = map[string]string{
+	"=": "eq", "!=": "ne", "<>": "ne", ">": "gt", ">=": "ge", "<": "lt", "<=": "le",
+}
+
+// The parseFilter() function parses a filter expression such as
+// `level eq "primary" and mode in (online, hybrid)`. Only the fields and
+// operators in the allow-list are accepted
+func parseFilter(input string, fields FilterFields) (filterNode, error) {
+	if len(input) > maxFilterLength {
+		return nil, fmt.Errorf("must not be more than %d bytes long", maxFilterLength)
+	}
+	tokens, err := lexFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens, fields: fields}
+	node, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return node, nil
+}
+
+// The kinds of token in a filter expression
+const (
+	tokenWord = iota
+	tokenString
+	tokenSymbol
+)
+
+type filterToken struct {
+	kind int
+	text string
+}
+
+// The lexFilter() function splits a filter expression into words, quoted
+// strings, the symbols ( ) , and the operator symbols
+func lexFilter(input string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, filterToken{kind: tokenSymbol, text: string(r)})
+			i++
+		case r == '"':
+			// Read up to the closing quote. A backslash escapes the next character
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: sb.String()})
+			i++
+		case strings.ContainsRune("<>=!", r):
+			start := i
+			for i < len(runes) && strings.ContainsRune("<>=!", runes[i]) {
+				i++
+			}
+			operator := string(runes[start:i])
+			if _, ok := symbolOperators[operator]; !ok {
+				return nil, fmt.Errorf("unknown operator %q", operator)
+			}
+			tokens = append(tokens, filterToken{kind: tokenSymbol, text: operator})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`(),"<>=!`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokenWord, text: string(runes[start:i])})
+		}
+	}
+	return tokens, nil
+}
+
+// A filterParser is a recursive descent parser over the tokens of a
+// filter expression. "and" binds tighter than "or"
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	fields FilterFields
+}
+
+// The peek() method returns the next token without consuming it
+func (p *filterParser) peek() (filterToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// The next() method consumes the next token
+func (p *filterParser) next() (filterToken, error) {
+	token, ok := p.peek()
+	if !ok {
+		return filterToken{}, errors.New("unexpected end of filter")
+	}
+	p.pos++
+	return token, nil
+}
+
+// The keyword() method consumes the next token if it is the given keyword
+func (p *filterParser) keyword(word string) bool {
+	token, ok := p.peek()
+	if ok && token.kind == tokenWord && strings.EqualFold(token.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// The symbol() method consumes the next token if it is the given symbol
+func (p *filterParser) symbol(symbol string) bool {
+	token, ok := p.peek()
+	if ok && token.kind == tokenSymbol && token.text == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr(depth int) (filterNode, error) {
+	node, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	operands := []filterNode{node}
+	for p.keyword("or") {
+		node, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, node)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return filterLogic{operator: "OR", operands: operands}, nil
+}
+
+func (p *filterParser) parseAnd(depth int) (filterNode, error) {
+	node, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	operands := []filterNode{node}
+	for p.keyword("and") {
+		node, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, node)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return filterLogic{operator: "AND", operands: operands}, nil
+}
+
+func (p *filterParser) parseUnary(depth int) (filterNode, error) {
+	if depth > maxFilterDepth {
+		return nil, fmt.Errorf("must not be nested more than %d levels deep", maxFilterDepth)
+	}
+	if p.keyword("not") {
+		node, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{operand: node}, nil
+	}
+	if p.symbol("(") {
+		node, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if !p.symbol(")") {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		return node, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	// The field must be in the allow-list
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	field, ok := p.fields[token.text]
+	if token.kind != tokenWord || !ok {
+		return nil, fmt.Errorf("unknown field %q", token.text)
+	}
+	// And so must the operator for that field
+	token, err = p.next()
+	if err != nil {
+		return nil, err
+	}
+	operator := strings.ToLower(token.text)
+	if token.kind == tokenSymbol {
+		operator = symbolOperators[token.text]
+	}
+	found := false
+	for _, allowed := range field.Operators {
+		found = found || operator == allowed
+	}
+	if token.kind == tokenString || !found {
+		return nil, fmt.Errorf("operator %q is not allowed on this field", token.text)
+	}
+	// "in" takes a list of values, every other operator takes one
+	var raw []string
+	if operator == "in" {
+		if !p.symbol("(") {
+			return nil, errors.New("in must be followed by a list of values")
+		}
+		for {
+			token, err := p.next()
+			if err != nil || token.kind == tokenSymbol {
+				return nil, errors.New("in must be followed by a list of values")
+			}
+			raw = append(raw, token.text)
+			if p.symbol(")") {
+				break
+			}
+			if !p.symbol(",") {
+				return nil, errors.New("values in a list must be separated by commas")
+			}
+		}
+	} else {
+		token, err := p.next()
+		if err != nil || token.kind == tokenSymbol {
+			return nil, fmt.Errorf("%s must be followed by a value", operator)
+		}
+		raw = append(raw, token.text)
+	}
+	// Convert the values to the type of the field
+	values := make([]interface{}, len(raw))
+	for i := range raw {
+		values[i], err = convertFilterValue(field.Type, raw[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return filterComparison{field: field, operator: operator, values: values}, nil
+}
+
+// The convertFilterValue() function converts a value from a filter
+// expression to the type of its field
+func convertFilterValue(fieldType FieldType, value string) (interface{}, error) {
+	switch fieldType {
+	case FieldInteger:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", value)
+		}
+		return i, nil
+	case FieldTime:
+		for _, layout := range []string{"2006-01-02", time.RFC3339} {
+			t, err := time.Parse(layout, value)
+			if err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a date (2006-01-02) or time (RFC 3339)", value)
+	case FieldNumber:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return f, nil
+	case FieldBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not true or false", value)
+		}
+		return b, nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,372 @@
+// Filename: internal/data/expressions_test.go
+
+package data
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The filterSQL() function parses a filter over the toast fields and
+// renders it with placeholders numbered from n
+func filterSQL(input string, n int) (string, []interface{}, error) {
+	node, err := parseFilter(input, ToastFilterFields)
+	if err != nil {
+		return "", nil, err
+	}
+	var args []interface{}
+	return node.sql(n, &args), args, nil
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "word operator",
+			input:    `level eq primary`,
+			wantSQL:  `(level = $1)`,
+			wantArgs: []interface{}{"primary"},
+		},
+		{
+			name:     "symbol operator",
+			input:    `id>=10`,
+			wantSQL:  `(id >= $1)`,
+			wantArgs: []interface{}{int64(10)},
+		},
+		{
+			name:     "not equal symbol",
+			input:    `version <> 3`,
+			wantSQL:  `(version <> $1)`,
+			wantArgs: []interface{}{int64(3)},
+		},
+		{
+			name:     "operators ignore case",
+			input:    `level EQ primary AND id Lt 5`,
+			wantSQL:  `((level = $1) AND (id < $2))`,
+			wantArgs: []interface{}{"primary", int64(5)},
+		},
+		{
+			name:     "date",
+			input:    `created_at ge 2023-01-02`,
+			wantSQL:  `(created_at >= $1)`,
+			wantArgs: []interface{}{time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:     "quoted string",
+			input:    `name eq "St. John's College"`,
+			wantSQL:  `(name = $1)`,
+			wantArgs: []interface{}{"St. John's College"},
+		},
+		{
+			name:     "keywords in quotes are values",
+			input:    `name eq "a or b"`,
+			wantSQL:  `(name = $1)`,
+			wantArgs: []interface{}{"a or b"},
+		},
+		{
+			name:     "escaped quote",
+			input:    `name eq "The \"Best\" School"`,
+			wantSQL:  `(name = $1)`,
+			wantArgs: []interface{}{`The "Best" School`},
+		},
+		{
+			name:     "escaped backslash",
+			input:    `name eq "a\\b"`,
+			wantSQL:  `(name = $1)`,
+			wantArgs: []interface{}{`a\b`},
+		},
+		{
+			name:     "contains escapes the LIKE wildcards",
+			input:    `name contains "50%_\\"`,
+			wantSQL:  `(name ILIKE $1)`,
+			wantArgs: []interface{}{`%50\%\_\\%`},
+		},
+		{
+			name:     "in list",
+			input:    `id in (1, 2,3)`,
+			wantSQL:  `(id = ANY($1))`,
+			wantArgs: []interface{}{pq.Int64Array{1, 2, 3}},
+		},
+		{
+			name:     "in list of quoted strings",
+			input:    `level in ("primary", "high school")`,
+			wantSQL:  `(level = ANY($1))`,
+			wantArgs: []interface{}{pq.StringArray{"primary", "high school"}},
+		},
+		{
+			name:     "array field",
+			input:    `mode eq online or tag ne sports or mode in (online, hybrid)`,
+			wantSQL:  `((mode @> ARRAY[$1]::text[]) OR (NOT tags @> ARRAY[$2]::text[]) OR (mode && $3))`,
+			wantArgs: []interface{}{"online", "sports", pq.StringArray{"online", "hybrid"}},
+		},
+		{
+			name:     "and binds tighter than or",
+			input:    `id eq 1 or id eq 2 and id eq 3`,
+			wantSQL:  `((id = $1) OR ((id = $2) AND (id = $3)))`,
+			wantArgs: []interface{}{int64(1), int64(2), int64(3)},
+		},
+		{
+			name:     "parentheses and not",
+			input:    `not (id eq 1 or id eq 2) and level eq primary`,
+			wantSQL:  `(NOT ((id = $1) OR (id = $2)) AND (level = $3))`,
+			wantArgs: []interface{}{int64(1), int64(2), "primary"},
+		},
+		{
+			name:     "deepest nesting allowed",
+			input:    strings.Repeat("(", maxFilterDepth) + "id eq 1" + strings.Repeat(")", maxFilterDepth),
+			wantSQL:  `(id = $1)`,
+			wantArgs: []interface{}{int64(1)},
+		},
+		{
+			name:     "longest filter allowed",
+			input:    `name eq "` + strings.Repeat("a", maxFilterLength-10) + `"`,
+			wantSQL:  `(name = $1)`,
+			wantArgs: []interface{}{strings.Repeat("a", maxFilterLength-10)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := filterSQL(tt.input, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("got %s, want %s", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("got args %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		// The field and operator allow-lists
+		{"unknown field", `password eq x`, `unknown field "password"`},
+		{"field names are case sensitive", `NAME eq x`, `unknown field "NAME"`},
+		{"quoted field", `"name" eq x`, `unknown field "name"`},
+		{"field that is a symbol", `= x`, `unknown field "="`},
+		{"unknown operator", `name like x`, `operator "like" is not allowed on this field`},
+		{"operator not allowed on field", `phone_e164 contains 501`, `operator "contains" is not allowed on this field`},
+		{"order on text", `name > x`, `operator ">" is not allowed on this field`},
+		{"in on a field without it", `version in (1)`, `operator "in" is not allowed on this field`},
+		{"quoted operator", `name "eq" x`, `operator "eq" is not allowed on this field`},
+		{"unknown symbol", `name == x`, `unknown operator "=="`},
+		{"lone bang", `name ! x`, `unknown operator "!"`},
+
+		// Values
+		{"missing value", `name eq`, `eq must be followed by a value`},
+		{"symbol as value", `name eq )`, `eq must be followed by a value`},
+		{"not an integer", `id eq abc`, `"abc" is not an integer`},
+		{"not a date", `created_at gt yesterday`, `"yesterday" is not a date (2006-01-02) or time (RFC 3339)`},
+		{"unterminated string", `name eq "St. John`, `unterminated string`},
+		{"escaped closing quote", `name eq "St. John\"`, `unterminated string`},
+
+		// In lists
+		{"in without list", `id in 1`, `in must be followed by a list of values`},
+		{"empty list", `id in ()`, `in must be followed by a list of values`},
+		{"trailing comma", `id in (1,)`, `in must be followed by a list of values`},
+		{"unclosed list", `id in (1,`, `in must be followed by a list of values`},
+		{"missing comma", `id in (1 2)`, `values in a list must be separated by commas`},
+		{"bad value in list", `id in (1, x)`, `"x" is not an integer`},
+
+		// Structure
+		{"empty", ``, `unexpected end of filter`},
+		{"dangling and", `id eq 1 and`, `unexpected end of filter`},
+		{"missing closing parenthesis", `(id eq 1`, `missing closing parenthesis`},
+		{"extra closing parenthesis", `id eq 1)`, `unexpected ")"`},
+		{"missing and", `id eq 1 id eq 2`, `unexpected "id"`},
+
+		// Limits
+		{
+			"nested too deep",
+			strings.Repeat("(", maxFilterDepth+1) + "id eq 1" + strings.Repeat(")", maxFilterDepth+1),
+			`must not be nested more than 10 levels deep`,
+		},
+		{
+			"not nested too deep",
+			strings.Repeat("not ", maxFilterDepth+1) + "id eq 1",
+			`must not be nested more than 10 levels deep`,
+		},
+		{
+			"too long",
+			`name eq "` + strings.Repeat("a", maxFilterLength-9) + `"`,
+			`must not be more than 1000 bytes long`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseFilter(tt.input, ToastFilterFields)
+			if err == nil {
+				t.Fatalf("parsed %q, want error %q", tt.input, tt.wantErr)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("got error %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLexFilter(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []filterToken
+	}{
+		{
+			input: `id>=10`,
+			want: []filterToken{
+				{tokenWord, "id"}, {tokenSymbol, ">="}, {tokenWord, "10"},
+			},
+		},
+		{
+			input: `attr.capacity!=5`,
+			want: []filterToken{
+				{tokenWord, "attr.capacity"}, {tokenSymbol, "!="}, {tokenWord, "5"},
+			},
+		},
+		{
+			input: `mode in(online,"face to face")`,
+			want: []filterToken{
+				{tokenWord, "mode"}, {tokenWord, "in"}, {tokenSymbol, "("}, {tokenWord, "online"},
+				{tokenSymbol, ","}, {tokenString, "face to face"}, {tokenSymbol, ")"},
+			},
+		},
+		{
+			input: `name eq ""`,
+			want: []filterToken{
+				{tokenWord, "name"}, {tokenWord, "eq"}, {tokenString, ""},
+			},
+		},
+		{
+			input: "\tname  eq\n\"a\\\"b\" ",
+			want: []filterToken{
+				{tokenWord, "name"}, {tokenWord, "eq"}, {tokenString, `a"b`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexFilter(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tokens, tt.want) {
+				t.Errorf("got %v, want %v", tokens, tt.want)
+			}
+		})
+	}
+}
+
+// The placeholderRegex matches the placeholders in a query
+var placeholderRegex = regexp.MustCompile(`\$(\d+)`)
+
+// GetAll() and Stats() number the filter's placeholders after the 12 of
+// the search and those of the cursor. Every placeholder must point at the
+// argument it was rendered for
+func TestFilterPlaceholders(t *testing.T) {
+	after := encodeCursor(cursor{Values: []string{"Wesley College"}, ID: 5})
+	tests := []struct {
+		name        string
+		filters     Filters
+		wantKeyset  []int
+		wantFilter  []int
+		wantValues  []interface{}
+		wantArgsLen int
+	}{
+		{
+			name: "filter without cursor",
+			filters: Filters{
+				Filter: `level eq primary or (mode in (online, hybrid) and name contains "Wesley")`,
+			},
+			wantFilter: []int{13, 14, 15},
+			wantValues: []interface{}{
+				"primary", pq.StringArray{"online", "hybrid"}, "%Wesley%",
+			},
+			wantArgsLen: 15,
+		},
+		{
+			name: "filter after cursor",
+			filters: Filters{
+				Sort:     "name",
+				SortList: []string{"id", "name"},
+				After:    after,
+				Filter:   `level eq primary or (mode in (online, hybrid) and name contains "Wesley")`,
+			},
+			wantKeyset: []int{13, 14},
+			wantFilter: []int{15, 16, 17},
+			wantValues: []interface{}{
+				"Wesley College", int64(5), "primary", pq.StringArray{"online", "hybrid"}, "%Wesley%",
+			},
+			wantArgsLen: 17,
+		},
+		{
+			name: "cursor without filter",
+			filters: Filters{
+				Sort:     "name",
+				SortList: []string{"id", "name"},
+				After:    after,
+			},
+			wantKeyset:  []int{13, 14},
+			wantValues:  []interface{}{"Wesley College", int64(5)},
+			wantArgsLen: 14,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filters.FilterFields = ToastFilterFields
+			// Build the arguments as GetAll() does
+			args := ToastSearch{}.args()
+			if len(args) != 12 {
+				t.Fatalf("the search has %d arguments, want 12", len(args))
+			}
+			keyset, keysetArgs := tt.filters.keyset(len(args) + 1)
+			args = append(args, keysetArgs...)
+			filter, filterArgs := tt.filters.filterCondition(len(args) + 1)
+			args = append(args, filterArgs...)
+
+			if len(args) != tt.wantArgsLen {
+				t.Errorf("got %d arguments, want %d", len(args), tt.wantArgsLen)
+			}
+			if got := placeholders(keyset); !reflect.DeepEqual(got, tt.wantKeyset) {
+				t.Errorf("keyset %s has placeholders %v, want %v", keyset, got, tt.wantKeyset)
+			}
+			if got := placeholders(filter); !reflect.DeepEqual(got, tt.wantFilter) {
+				t.Errorf("filter %s has placeholders %v, want %v", filter, got, tt.wantFilter)
+			}
+			if !reflect.DeepEqual(args[12:], tt.wantValues) {
+				t.Errorf("got arguments %#v, want %#v", args[12:], tt.wantValues)
+			}
+		})
+	}
+}
+
+// The placeholders() function returns the distinct placeholder numbers in
+// a condition in the order they first appear. A condition that is always
+// true has none
+func placeholders(condition string) []int {
+	var numbers []int
+	seen := map[int]bool{}
+	for _, match := range placeholderRegex.FindAllStringSubmatch(condition, -1) {
+		n, _ := strconv.Atoi(match[1])
+		if !seen[n] {
+			seen[n] = true
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
@@ -3,6 +3,10 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"strings"
 
@@ -14,6 +18,14 @@ type Filters struct {
 	PageSize int
 	Sort     string
 	SortList []string
+	After    string // cursor of the row just before the page
+	Before   string // cursor of the row just after the page
+	// A filter expression over the fields in FilterFields
+	Filter       string
+	FilterFields FilterFields
+	// The fields to return, taken from FieldList. None means all of them
+	Fields    []string
+	FieldList []string
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -22,26 +34,216 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page <= 1000, "page", "must be a maximum of 1000")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
-	// Check that the sort parameter matches a value in the acceptable sort list
-	v.Check(validator.In(f.Sort, f.SortList...), "sort", "invalid sort value")
+	// Check that each comma separated sort key matches a value in the
+	// acceptable sort list, and that no column is used twice
+	keys := strings.Split(f.Sort, ",")
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		v.Check(validator.In(key, f.SortList...), "sort", "invalid sort value")
+		columns[i] = strings.TrimPrefix(key, "-")
+	}
+	v.Check(len(keys) <= 3, "sort", "must not contain more than 3 keys")
+	v.Check(validator.Unique(columns), "sort", "must not contain the same column more than once")
+	// Check the cursors. Only one direction can be used at a time and a
+	// cursor holds a value for each sort key
+	v.Check(f.After == "" || f.Before == "", "before", "must not be used together with after")
+	if f.After != "" {
+		c, err := decodeCursor(f.After)
+		v.Check(err == nil && len(c.Values) == len(keys), "after", "must be a valid cursor")
+	}
+	if f.Before != "" {
+		c, err := decodeCursor(f.Before)
+		v.Check(err == nil && len(c.Values) == len(keys), "before", "must be a valid cursor")
+	}
+	// Check that only known fields are requested
+	if len(f.Fields) > 0 {
+		ValidateFields(v, f.Fields, f.FieldList)
+	}
+	// Check that the filter expression only uses the allowed fields
+	if f.Filter != "" {
+		_, err := parseFilter(f.Filter, f.FilterFields)
+		if err != nil {
+			v.AddError("filter", err.Error())
+		}
+	}
+}
+
+// ValidateFields checks that each requested field is in the field list
+func ValidateFields(v *validator.Validator, fields []string, fieldList []string) {
+	for _, field := range fields {
+		v.Check(validator.In(field, fieldList...), "fields", fmt.Sprintf("invalid field %q", field))
+	}
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate entries")
+}
+
+// A cursor marks a position in a sorted listing. It holds the values of
+// the sort columns and the id of the row at that position. Clients only
+// ever see it encoded
+type cursor struct {
+	Values []string `json:"v"`
+	ID     int64    `json:"id"`
+}
+
+// The encodeCursor() function turns a cursor into an opaque string
+func encodeCursor(c cursor) string {
+	js, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// The decodeCursor() function reads a cursor created by encodeCursor()
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, err
+	}
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return cursor{}, err
+	}
+	if c.ID < 1 {
+		return cursor{}, errors.New("invalid cursor id")
+	}
+	return c, nil
+}
+
+// A sortKey is a column to sort by and its direction
+type sortKey struct {
+	column string
+	order  string
+}
+
+// The sortColumns() method safety extracts the comma separated keys of the
+// sort query parameter, checking each against the safelist
+func (f Filters) sortColumns() []sortKey {
+	var keys []sortKey
+	for _, key := range strings.Split(f.Sort, ",") {
+		if !validator.In(key, f.SortList...) {
+			panic("unsafe sort parameter: " + f.Sort)
+		}
+		// A leading "-" sorts by DESC instead of ASC
+		order := "ASC"
+		if strings.HasPrefix(key, "-") {
+			order = "DESC"
+		}
+		keys = append(keys, sortKey{column: strings.TrimPrefix(key, "-"), order: order})
+	}
+	return keys
+}
+
+// The usesCursor() method reports whether the page is picked by a cursor
+// rather than a page number
+func (f Filters) usesCursor() bool {
+	return f.After != "" || f.Before != ""
+}
+
+// The orderKeys() method returns the keys the rows are read in, with the
+// id as the final tie-breaker so the order is stable. Paging backwards
+// reads the rows in reverse
+func (f Filters) orderKeys() []sortKey {
+	keys := f.sortColumns()
+	hasID := false
+	for _, key := range keys {
+		hasID = hasID || key.column == "id"
+	}
+	if !hasID {
+		keys = append(keys, sortKey{column: "id", order: "ASC"})
+	}
+	if f.Before != "" {
+		for i := range keys {
+			keys[i].order = reverseOrder(keys[i].order)
+		}
+	}
+	return keys
+}
+
+// The orderBy() method builds the ORDER BY list
+func (f Filters) orderBy() string {
+	var parts []string
+	for _, key := range f.orderKeys() {
+		parts = append(parts, key.column+" "+key.order)
+	}
+	return strings.Join(parts, ", ")
 }
 
-// The sortColumn() method safety extracts the sort field query parameter
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortList {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+// The cursorColumns() method returns an array of the text of each sort
+// column, which is read with every row to build its cursor
+func (f Filters) cursorColumns() string {
+	var columns []string
+	for _, key := range f.sortColumns() {
+		columns = append(columns, key.column+"::text")
+	}
+	return "ARRAY[" + strings.Join(columns, ", ") + "]"
+}
+
+// The keyset() method returns the condition selecting the rows beyond the
+// cursor in the sort order, along with its arguments. Its placeholders are
+// numbered from n. Without a cursor the condition is always true
+func (f Filters) keyset(n int) (string, []interface{}) {
+	if !f.usesCursor() {
+		return "TRUE", nil
+	}
+	raw := f.After
+	if f.Before != "" {
+		raw = f.Before
+	}
+	c, err := decodeCursor(raw)
+	if err != nil {
+		panic("unchecked cursor: " + raw)
+	}
+	// One value per key, with the id for the tie-breaker
+	keys := f.orderKeys()
+	args := make([]interface{}, 0, len(keys))
+	for _, value := range c.Values {
+		args = append(args, value)
+	}
+	if len(args) < len(keys) {
+		args = append(args, c.ID)
+	}
+	// A row is beyond the cursor when the keys before some key are equal
+	// to the cursor and that key is past it:
+	// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+	alternatives := make([]string, len(keys))
+	for i, key := range keys {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = $%d", keys[j].column, n+j))
+		}
+		operator := ">"
+		if key.order == "DESC" {
+			operator = "<"
 		}
+		terms = append(terms, fmt.Sprintf("%s %s $%d", key.column, operator, n+i))
+		alternatives[i] = "(" + strings.Join(terms, " AND ") + ")"
 	}
-	panic("unsafe sort parameter: " + f.Sort)
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
 }
 
-// The sortOrder() method determines whether we should sort by DESC/ASC
-func (f Filters) sortOrder() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+// The filterCondition() method compiles the filter expression into a SQL
+// condition and its arguments. Its placeholders are numbered from n.
+// Without a filter the condition is always true
+func (f Filters) filterCondition(n int) (string, []interface{}) {
+	if f.Filter == "" {
+		return "TRUE", nil
 	}
-	return "ASC"
+	node, err := parseFilter(f.Filter, f.FilterFields)
+	if err != nil {
+		panic("unchecked filter: " + f.Filter)
+	}
+	var args []interface{}
+	condition := node.sql(n, &args)
+	return condition, args
+}
+
+// The reverseOrder() function swaps ASC and DESC
+func reverseOrder(order string) string {
+	if order == "DESC" {
+		return "ASC"
+	}
+	return "DESC"
 }
 
 // The limit() method determines the LIMIT
@@ -49,18 +251,23 @@ func (f Filters) limit() int {
 	return f.PageSize
 }
 
-// The offset() method calculates the OFFSET
+// The offset() method calculates the OFFSET. Cursors replace the page number
 func (f Filters) offset() int {
+	if f.usesCursor() {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
 
 // The Metadata type contains metadata to help with pagination
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 // The calculateMetadata() function computes the values for the Metadata fields
@@ -76,3 +283,29 @@ func calculateMetadata(totalRecrods int, page int, pageSize int) Metadata {
 		TotalRecords: totalRecrods,
 	}
 }
+
+// The calculateCursorMetadata() function adds the cursors of the first and
+// last rows of a page to the metadata. The more parameter reports whether
+// a row was found beyond the page in the direction we read
+func (f Filters) calculateCursorMetadata(metadata Metadata, first, last cursor, more bool) Metadata {
+	switch {
+	case f.After != "":
+		metadata.PrevCursor = encodeCursor(first)
+		if more {
+			metadata.NextCursor = encodeCursor(last)
+		}
+	case f.Before != "":
+		metadata.NextCursor = encodeCursor(last)
+		if more {
+			metadata.PrevCursor = encodeCursor(first)
+		}
+	default:
+		if f.Page > 1 {
+			metadata.PrevCursor = encodeCursor(first)
+		}
+		if more {
+			metadata.NextCursor = encodeCursor(last)
+		}
+	}
+	return metadata
+}
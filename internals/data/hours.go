@@ -0,0 +1,223 @@
+// Filename: internal/data/hours.go
+
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"toaster.jalen.net/internals/validator"
+)
+
+// An Interval is a stretch of a day a toast is open, as "HH:MM" local
+// times. Close is after Open; "24:00" closes at midnight. An interval
+// can't run past midnight, so a toast open from 22:00 to 02:00 has 22:00 to
+// 24:00 on one day and 00:00 to 02:00 on the next
+type Interval struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+// WeeklyHours holds the intervals a toast is open on each day of the week.
+// A day without intervals is closed
+type WeeklyHours struct {
+	Monday    []Interval `json:"monday"`
+	Tuesday   []Interval `json:"tuesday"`
+	Wednesday []Interval `json:"wednesday"`
+	Thursday  []Interval `json:"thursday"`
+	Friday    []Interval `json:"friday"`
+	Saturday  []Interval `json:"saturday"`
+	Sunday    []Interval `json:"sunday"`
+}
+
+// The days() method returns the intervals of each day, named as in JSON
+func (w *WeeklyHours) days() []struct {
+	name      string
+	weekday   time.Weekday
+	intervals *[]Interval
+} {
+	return []struct {
+		name      string
+		weekday   time.Weekday
+		intervals *[]Interval
+	}{
+		{"monday", time.Monday, &w.Monday},
+		{"tuesday", time.Tuesday, &w.Tuesday},
+		{"wednesday", time.Wednesday, &w.Wednesday},
+		{"thursday", time.Thursday, &w.Thursday},
+		{"friday", time.Friday, &w.Friday},
+		{"saturday", time.Saturday, &w.Saturday},
+		{"sunday", time.Sunday, &w.Sunday},
+	}
+}
+
+// An HoursException replaces the weekly hours on a date ("YYYY-MM-DD"),
+// such as a public holiday. No intervals means closed all day
+type HoursException struct {
+	Date      string     `json:"date"`
+	Note      string     `json:"note,omitempty"`
+	Intervals []Interval `json:"intervals"`
+}
+
+// OpeningHours is the schedule of a toast, in its own time zone. It is
+// stored as jsonb and read by the toast_is_open() SQL function, which must
+// agree with OpenAt()
+type OpeningHours struct {
+	TimeZone   string           `json:"time_zone"`
+	Weekly     WeeklyHours      `json:"weekly"`
+	Exceptions []HoursException `json:"exceptions"`
+}
+
+// The Value() method stores the opening hours as a JSON object
+func (h OpeningHours) Value() (driver.Value, error) {
+	js, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return string(js), nil
+}
+
+// The Scan() method reads the opening hours from a jsonb column
+func (h *OpeningHours) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, h)
+	case string:
+		return json.Unmarshal([]byte(src), h)
+	default:
+		return fmt.Errorf("cannot scan %T into opening hours", src)
+	}
+}
+
+// Normalize() puts the intervals and exceptions in order and lists closed
+// days as empty rather than null
+func (h *OpeningHours) Normalize() {
+	for _, day := range h.Weekly.days() {
+		*day.intervals = sortIntervals(*day.intervals)
+	}
+	for i := range h.Exceptions {
+		h.Exceptions[i].Intervals = sortIntervals(h.Exceptions[i].Intervals)
+	}
+	if h.Exceptions == nil {
+		h.Exceptions = []HoursException{}
+	}
+	sort.SliceStable(h.Exceptions, func(i, j int) bool {
+		return h.Exceptions[i].Date < h.Exceptions[j].Date
+	})
+}
+
+// OpenAt() reports whether the toast is open at t
+func (h *OpeningHours) OpenAt(t time.Time) bool {
+	location, err := time.LoadLocation(h.TimeZone)
+	if err != nil {
+		return false
+	}
+	local := t.In(location)
+	var intervals []Interval
+	for _, day := range h.Weekly.days() {
+		if day.weekday == local.Weekday() {
+			intervals = *day.intervals
+		}
+	}
+	// An exception for the date takes the place of the weekly hours
+	date := local.Format("2006-01-02")
+	for _, exception := range h.Exceptions {
+		if exception.Date == date {
+			intervals = exception.Intervals
+			break
+		}
+	}
+	minute := local.Hour()*60 + local.Minute()
+	for _, interval := range intervals {
+		open, _ := parseClock(interval.Open)
+		close, _ := parseClock(interval.Close)
+		if open <= minute && minute < close {
+			return true
+		}
+	}
+	return false
+}
+
+// The validateHours() function checks the opening hours of a toast. Errors
+// are reported under "hours.<part>"
+func validateHours(v *validator.Validator, hours *OpeningHours) {
+	v.Check(hours.TimeZone != "", "hours.time_zone", "must be provided")
+	// LoadLocation() also takes "" and "Local", which aren't time zone names
+	_, err := time.LoadLocation(hours.TimeZone)
+	v.Check(err == nil && hours.TimeZone != "Local", "hours.time_zone", "must be a valid IANA time zone name")
+
+	for _, day := range hours.Weekly.days() {
+		validateIntervals(v, "hours.weekly."+day.name, *day.intervals)
+	}
+
+	v.Check(len(hours.Exceptions) <= 100, "hours.exceptions", "must contain at most 100 entries")
+	dates := make([]string, len(hours.Exceptions))
+	for i, exception := range hours.Exceptions {
+		key := fmt.Sprintf("hours.exceptions.%d", i)
+		_, err := time.Parse("2006-01-02", exception.Date)
+		v.Check(err == nil, key+".date", "must be a date in YYYY-MM-DD format")
+		v.Check(len(exception.Note) <= 200, key+".note", "must not be more than 200 bytes long")
+		validateIntervals(v, key+".intervals", exception.Intervals)
+		dates[i] = exception.Date
+	}
+	v.Check(validator.Unique(dates), "hours.exceptions", "must not contain more than one entry for a date")
+}
+
+// The validateIntervals() function checks the intervals of one day: each
+// must be well formed and none may overlap another. An interval that closes
+// at or before it opens is rejected, so overnight hours such as 22:00 to
+// 02:00 can't be entered as one interval and must be split at midnight
+func validateIntervals(v *validator.Validator, key string, intervals []Interval) {
+	v.Check(len(intervals) <= 10, key, "must contain at most 10 intervals")
+	for _, interval := range intervals {
+		open, okOpen := parseClock(interval.Open)
+		close, okClose := parseClock(interval.Close)
+		if !okOpen || !okClose || open == 24*60 {
+			v.AddError(key, "must contain times in HH:MM format")
+			return
+		}
+		if close <= open {
+			v.AddError(key, "must contain intervals that close after they open")
+			return
+		}
+	}
+	sorted := sortIntervals(intervals)
+	for i := 1; i < len(sorted); i++ {
+		previous, _ := parseClock(sorted[i-1].Close)
+		open, _ := parseClock(sorted[i].Open)
+		v.Check(open >= previous, key, "must not contain overlapping intervals")
+	}
+}
+
+// The sortIntervals() function returns a copy of the intervals ordered by
+// opening time. It never returns nil
+func sortIntervals(intervals []Interval) []Interval {
+	sorted := append([]Interval{}, intervals...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Open < sorted[j].Open
+	})
+	return sorted
+}
+
+// The parseClock() function returns the minutes since midnight of an
+// "HH:MM" time from "00:00" to "24:00"
+func parseClock(s string) (int, bool) {
+	if len(s) != 5 || s[2] != ':' {
+		return 0, false
+	}
+	var hour, minute int
+	for _, c := range s[:2] + s[3:] {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	hour = int(s[0]-'0')*10 + int(s[1]-'0')
+	minute = int(s[3]-'0')*10 + int(s[4]-'0')
+	if minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, false
+	}
+	return hour*60 + minute, true
+}
@@ -0,0 +1,241 @@
+// Filename: internal/data/hours_test.go
+
+package data
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"toaster.jalen.net/internals/validator"
+)
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   int
+		wantOK bool
+	}{
+		{"00:00", 0, true},
+		{"09:30", 570, true},
+		{"23:59", 1439, true},
+		{"24:00", 1440, true},
+		{"24:01", 0, false},
+		{"25:00", 0, false},
+		{"12:60", 0, false},
+		{"9:30", 0, false},
+		{"09-30", 0, false},
+		{"09:3a", 0, false},
+		{"-1:00", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := parseClock(tt.input)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("got %d, %t, want %d, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateHours(t *testing.T) {
+	weekly := func(monday ...Interval) OpeningHours {
+		return OpeningHours{TimeZone: "America/Belize", Weekly: WeeklyHours{Monday: monday}}
+	}
+	tooMany := make([]Interval, 11)
+	for i := range tooMany {
+		tooMany[i] = Interval{fmt.Sprintf("%02d:00", i), fmt.Sprintf("%02d:30", i)}
+	}
+	tests := []struct {
+		name       string
+		hours      OpeningHours
+		wantErrors map[string]string
+	}{
+		{
+			name:  "valid",
+			hours: weekly(Interval{"08:00", "12:00"}, Interval{"13:00", "17:00"}),
+		},
+		{
+			name:  "closes at midnight",
+			hours: weekly(Interval{"18:00", "24:00"}),
+		},
+		{
+			name:  "intervals that touch",
+			hours: weekly(Interval{"13:00", "17:00"}, Interval{"08:00", "13:00"}),
+		},
+		{
+			name:  "closed all week",
+			hours: OpeningHours{TimeZone: "UTC"},
+		},
+		{
+			name:  "overlapping intervals",
+			hours: weekly(Interval{"08:00", "12:00"}, Interval{"11:00", "17:00"}),
+			wantErrors: map[string]string{
+				"hours.weekly.monday": "must not contain overlapping intervals",
+			},
+		},
+		{
+			name:  "interval inside another",
+			hours: weekly(Interval{"08:00", "17:00"}, Interval{"12:00", "13:00"}),
+			wantErrors: map[string]string{
+				"hours.weekly.monday": "must not contain overlapping intervals",
+			},
+		},
+		{
+			// Overnight hours must be split at midnight
+			name:  "overnight interval",
+			hours: weekly(Interval{"22:00", "02:00"}),
+			wantErrors: map[string]string{
+				"hours.weekly.monday": "must contain intervals that close after they open",
+			},
+		},
+		{
+			name:  "empty interval",
+			hours: weekly(Interval{"09:00", "09:00"}),
+			wantErrors: map[string]string{
+				"hours.weekly.monday": "must contain intervals that close after they open",
+			},
+		},
+		{
+			name:  "opens at 24:00",
+			hours: weekly(Interval{"24:00", "24:00"}),
+			wantErrors: map[string]string{
+				"hours.weekly.monday": "must contain times in HH:MM format",
+			},
+		},
+		{
+			name:  "bad time",
+			hours: weekly(Interval{"8am", "12:00"}),
+			wantErrors: map[string]string{
+				"hours.weekly.monday": "must contain times in HH:MM format",
+			},
+		},
+		{
+			name:  "too many intervals",
+			hours: OpeningHours{TimeZone: "UTC", Exceptions: []HoursException{{Date: "2023-01-09", Intervals: tooMany}}},
+			wantErrors: map[string]string{
+				"hours.exceptions.0.intervals": "must contain at most 10 intervals",
+			},
+		},
+		{
+			name:  "missing time zone",
+			hours: OpeningHours{},
+			wantErrors: map[string]string{
+				"hours.time_zone": "must be provided",
+			},
+		},
+		{
+			name:  "unknown time zone",
+			hours: OpeningHours{TimeZone: "America/Belmopan"},
+			wantErrors: map[string]string{
+				"hours.time_zone": "must be a valid IANA time zone name",
+			},
+		},
+		{
+			name:  "local time zone",
+			hours: OpeningHours{TimeZone: "Local"},
+			wantErrors: map[string]string{
+				"hours.time_zone": "must be a valid IANA time zone name",
+			},
+		},
+		{
+			name: "bad exceptions",
+			hours: OpeningHours{TimeZone: "UTC", Exceptions: []HoursException{
+				{Date: "09/01/2023"},
+				{Date: "2023-01-10", Note: strings.Repeat("a", 201), Intervals: []Interval{{"17:00", "08:00"}}},
+			}},
+			wantErrors: map[string]string{
+				"hours.exceptions.0.date":      "must be a date in YYYY-MM-DD format",
+				"hours.exceptions.1.note":      "must not be more than 200 bytes long",
+				"hours.exceptions.1.intervals": "must contain intervals that close after they open",
+			},
+		},
+		{
+			name: "two exceptions for a date",
+			hours: OpeningHours{TimeZone: "UTC", Exceptions: []HoursException{
+				{Date: "2023-01-09"}, {Date: "2023-01-09", Intervals: []Interval{{"10:00", "11:00"}}},
+			}},
+			wantErrors: map[string]string{
+				"hours.exceptions": "must not contain more than one entry for a date",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			validateHours(v, &tt.hours)
+			if len(v.Errors) != len(tt.wantErrors) {
+				t.Errorf("got errors %v, want %v", v.Errors, tt.wantErrors)
+			}
+			for key, message := range tt.wantErrors {
+				if v.Errors[key] != message {
+					t.Errorf("%s: got %q, want %q", key, v.Errors[key], message)
+				}
+			}
+		})
+	}
+}
+
+// The migration's toast_is_open() SQL function mirrors OpenAt(), so these
+// cases describe both
+func TestOpenAt(t *testing.T) {
+	// Belize is six hours behind UTC all year. 2 January 2023 is a Monday
+	hours := OpeningHours{
+		TimeZone: "America/Belize",
+		Weekly: WeeklyHours{
+			Monday:   []Interval{{"08:00", "12:00"}, {"13:00", "17:00"}},
+			Friday:   []Interval{{"18:00", "24:00"}},
+			Saturday: []Interval{{"00:00", "02:00"}},
+		},
+		Exceptions: []HoursException{
+			{Date: "2023-01-09", Note: "Public holiday", Intervals: []Interval{}},
+			{Date: "2023-01-10", Intervals: []Interval{{"10:00", "11:00"}}},
+			{Date: "2023-01-14", Intervals: []Interval{}},
+		},
+	}
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2023, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"opening time", utc(1, 2, 14, 0), true},
+		{"before opening", utc(1, 2, 13, 59), false},
+		{"closing time", utc(1, 2, 18, 0), false},
+		{"between intervals", utc(1, 2, 18, 30), false},
+		{"second interval", utc(1, 2, 19, 0), true},
+		{"closed day", utc(1, 4, 16, 0), false},
+
+		// The day is the toast's, not UTC's
+		{"Friday evening is Saturday in UTC", utc(1, 7, 3, 0), true},
+		{"last minute before a 24:00 close", utc(1, 7, 5, 59), true},
+		{"Saturday opens at midnight", utc(1, 7, 6, 0), true},
+		{"Saturday ends at 02:00", utc(1, 7, 8, 0), false},
+
+		// Exceptions take the place of the weekly hours
+		{"holiday on a Monday", utc(1, 9, 15, 0), false},
+		{"the Monday after", utc(1, 16, 15, 0), true},
+		{"extra hours on a closed day", utc(1, 10, 16, 30), true},
+		{"outside the extra hours", utc(1, 10, 17, 0), false},
+		{"exception by local date", utc(1, 14, 3, 0), true},
+		{"exception closes the Saturday", utc(1, 14, 6, 30), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hours.OpenAt(tt.at); got != tt.want {
+				t.Errorf("OpenAt(%s) = %t, want %t", tt.at.Format(time.RFC3339), got, tt.want)
+			}
+		})
+	}
+
+	// Hours in an unknown time zone are never open
+	unknown := OpeningHours{TimeZone: "Nowhere/Nothing", Weekly: WeeklyHours{Monday: []Interval{{"00:00", "24:00"}}}}
+	if unknown.OpenAt(utc(1, 2, 12, 0)) {
+		t.Error("hours in an unknown time zone are open")
+	}
+}
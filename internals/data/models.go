@@ -14,18 +14,32 @@ var (
 
 // A wrapper for our data models
 type Models struct {
-	Permissions PermissionModel
-	Toasts      ToastModel
-	Tokens      TokenModel
-	Users       UserModel
+	Attachments  AttachmentModel
+	Attributes   AttributeModel
+	Contacts     ContactModel
+	Events       EventModel
+	Permissions  PermissionModel
+	Toasts       ToastModel
+	Tokens       TokenModel
+	Translations TranslationModel
+	Users        UserModel
+	Vocabularies VocabularyModel
+	Webhooks     WebhookModel
 }
 
 // NewModels() allows us to create a new Models
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Permissions: PermissionModel{DB: db},
-		Toasts:      ToastModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		Attachments:  AttachmentModel{DB: db},
+		Attributes:   AttributeModel{DB: db},
+		Contacts:     ContactModel{DB: db},
+		Events:       EventModel{DB: db},
+		Permissions:  PermissionModel{DB: db},
+		Toasts:       ToastModel{DB: db},
+		Tokens:       TokenModel{DB: db},
+		Translations: TranslationModel{DB: db},
+		Users:        UserModel{DB: db},
+		Vocabularies: VocabularyModel{DB: db},
+		Webhooks:     WebhookModel{DB: db},
 	}
 }
@@ -0,0 +1,90 @@
+// Filename: internal/data/stats.go
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A Count is the number of toasts with a value
+type Count struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// ToastStats holds the counts of the toasts matching a search. A toast is
+// counted once for each of its modes. Months are "YYYY-MM" in UTC
+type ToastStats struct {
+	Total   int     `json:"total"`
+	ByLevel []Count `json:"by_level"`
+	ByMode  []Count `json:"by_mode"`
+	ByMonth []Count `json:"by_month"`
+}
+
+// GetStats() counts the toasts matching the search and filter expression
+// by level, by mode and by the month they were created in. Levels and
+// modes are listed most common first, months in order
+func (m ToastModel) GetStats(search ToastSearch, filters Filters) (ToastStats, error) {
+	args := search.args()
+	filter, filterArgs := filters.filterCondition(len(args) + 1)
+	args = append(args, filterArgs...)
+	// Each row of the result is one count of one facet
+	query := fmt.Sprintf(`
+		WITH matches AS (
+			SELECT level, mode, created_at
+			FROM toasts
+			WHERE %s
+			AND %s
+		)
+		SELECT facet, value, count
+		FROM (
+			SELECT 'total' AS facet, '' AS value, COUNT(*) AS count FROM matches
+			UNION ALL
+			SELECT 'level', level, COUNT(*) FROM matches GROUP BY level
+			UNION ALL
+			SELECT 'mode', m, COUNT(*) FROM matches, unnest(mode) AS m GROUP BY m
+			UNION ALL
+			SELECT 'month', to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM') AS month, COUNT(*)
+			FROM matches GROUP BY month
+		) AS counts
+		ORDER BY facet, CASE WHEN facet = 'month' THEN value END ASC, count DESC, value ASC
+	`, toastSearchCondition, filter)
+	// Create a 3-second-timout context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.beginSearch(ctx, search)
+	if err != nil {
+		return ToastStats{}, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return ToastStats{}, err
+	}
+	defer rows.Close()
+	stats := ToastStats{ByLevel: []Count{}, ByMode: []Count{}, ByMonth: []Count{}}
+	for rows.Next() {
+		var facet string
+		var count Count
+		err := rows.Scan(&facet, &count.Value, &count.Count)
+		if err != nil {
+			return ToastStats{}, err
+		}
+		switch facet {
+		case "total":
+			stats.Total = count.Count
+		case "level":
+			stats.ByLevel = append(stats.ByLevel, count)
+		case "mode":
+			stats.ByMode = append(stats.ByMode, count)
+		case "month":
+			stats.ByMonth = append(stats.ByMonth, count)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return ToastStats{}, err
+	}
+	return stats, nil
+}
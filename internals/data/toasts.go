@@ -7,39 +7,151 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 	"toaster.jalen.net/internals/validator"
 )
 
+var (
+	ErrDuplicateExternalID = errors.New("duplicate external id")
+)
+
 type Toast struct {
 	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 	Name      string    `json:"name"`
 	Level     string    `json:"level"`
 	Contact   string    `json:"contact"`
 	Phone     string    `json:"phone"`
+	PhoneE164 string    `json:"phone_e164,omitempty"`
 	Email     string    `json:"email,omitempty"`
 	Website   string    `json:"website,omitempty"`
 	Address   string    `json:"address"`
 	Mode      []string  `json:"mode"`
-	Version   int32     `json:"version"`
+	Tags      []string  `json:"tags,omitempty"`
+	// Custom attributes, checked against the attribute schemas
+	Attributes Attributes `json:"attributes,omitempty"`
+	// The weekly schedule and its exceptions. OpenNow is worked out when a
+	// single toast is shown
+	Hours          *OpeningHours `json:"hours,omitempty"`
+	OpenNow        *bool         `json:"open_now,omitempty"`
+	ExternalSource string        `json:"external_source,omitempty"`
+	ExternalID     string        `json:"external_id,omitempty"`
+	Latitude       *float64      `json:"latitude,omitempty"`
+	Longitude      *float64      `json:"longitude,omitempty"`
+	// The user who created the toast, unknown for the oldest toasts
+	CreatedBy  *int64   `json:"created_by,omitempty"`
+	Version    int32    `json:"version"`
+	Snippet    string   `json:"snippet,omitempty"`
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+	// Only read when the client asks for them to be embedded
+	Contacts []*Contact `json:"contacts,omitempty"`
+}
+
+// ToastSearch holds the criteria GetAll() picks toasts by
+type ToastSearch struct {
+	Name  string
+	Level string
+	Mode  []string
+	// Only toasts with all of these tags
+	Tags []string
+	// A phone number in E.164, so any formatting of it finds the toast
+	Phone string
+	// Matched against all the text fields using websearch_to_tsquery()
+	// syntax ("quoted phrases", or, -excluded)
+	Search string
+	// Matches names with a trigram similarity of at least Similarity, so
+	// misspelled names are still found
+	NameFuzzy  string
+	Similarity float64
+	// Only toasts within RadiusKm of the point, when Near is set
+	Near      bool
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+	// Only toasts whose opening hours say they are open at OpenAt, when set
+	OpenAt *time.Time
+}
+
+// The columns behind the JSON fields of a toast, in select order, with the
+// destination each one is scanned into
+var toastColumns = []struct {
+	field  string
+	column string
+	dest   func(toast *Toast) interface{}
+}{
+	{"id", "id", func(toast *Toast) interface{} { return &toast.ID }},
+	{"created_at", "created_at", func(toast *Toast) interface{} { return &toast.CreatedAt }},
+	{"updated_at", "updated_at", func(toast *Toast) interface{} { return &toast.UpdatedAt }},
+	{"name", "name", func(toast *Toast) interface{} { return &toast.Name }},
+	{"level", "level", func(toast *Toast) interface{} { return &toast.Level }},
+	{"contact", "contact", func(toast *Toast) interface{} { return &toast.Contact }},
+	{"phone", "phone", func(toast *Toast) interface{} { return &toast.Phone }},
+	{"phone_e164", "phone_e164", func(toast *Toast) interface{} { return &toast.PhoneE164 }},
+	{"email", "email", func(toast *Toast) interface{} { return &toast.Email }},
+	{"website", "website", func(toast *Toast) interface{} { return &toast.Website }},
+	{"address", "address", func(toast *Toast) interface{} { return &toast.Address }},
+	{"mode", "mode", func(toast *Toast) interface{} { return pq.Array(&toast.Mode) }},
+	{"tags", "tags", func(toast *Toast) interface{} { return pq.Array(&toast.Tags) }},
+	{"attributes", "attributes", func(toast *Toast) interface{} { return &toast.Attributes }},
+	{"hours", "hours", func(toast *Toast) interface{} { return &toast.Hours }},
+	{"external_source", "external_source", func(toast *Toast) interface{} { return &toast.ExternalSource }},
+	{"external_id", "external_id", func(toast *Toast) interface{} { return &toast.ExternalID }},
+	{"latitude", "latitude", func(toast *Toast) interface{} { return &toast.Latitude }},
+	{"longitude", "longitude", func(toast *Toast) interface{} { return &toast.Longitude }},
+	{"created_by", "created_by", func(toast *Toast) interface{} { return &toast.CreatedBy }},
+	{"version", "version", func(toast *Toast) interface{} { return &toast.Version }},
+}
+
+// The JSON fields of a toast that a client can ask for
+var ToastFields = []string{
+	"id", "created_at", "updated_at", "name", "level", "contact", "phone", "phone_e164",
+	"email", "website", "address", "mode", "tags", "attributes", "hours", "external_source",
+	"external_id", "latitude", "longitude", "created_by", "version",
 }
 
-func ValidateToast(v *validator.Validator, toast *Toast) {
+// The toastSelection() function returns the column list for the requested
+// fields and a function that gives the scan destinations in a toast. The id
+// and version are always read. No fields means every column
+func toastSelection(fields []string) (string, func(toast *Toast) []interface{}) {
+	var columns []string
+	var dests []func(toast *Toast) interface{}
+	for _, c := range toastColumns {
+		if len(fields) == 0 || c.field == "id" || c.field == "version" || validator.In(c.field, fields...) {
+			columns = append(columns, c.column)
+			dests = append(dests, c.dest)
+		}
+	}
+	scan := func(toast *Toast) []interface{} {
+		values := make([]interface{}, len(dests))
+		for i := range dests {
+			values[i] = dests[i](toast)
+		}
+		return values
+	}
+	return strings.Join(columns, ", "), scan
+}
+
+func ValidateToast(v *validator.Validator, toast *Toast, vocabulary Vocabulary) {
 	// Use the Check() method to execute our validation checks
 	v.Check(toast.Name != "", "name", "must be provided")
 	v.Check(len(toast.Name) <= 200, "name", "must not be more than 200 bytes long")
 
 	v.Check(toast.Level != "", "level", "must be provided")
 	v.Check(len(toast.Level) <= 200, "level", "must not be more than 200 bytes long")
+	v.Check(validator.In(toast.Level, vocabulary.Levels...), "level", "must be one of the allowed levels")
 
 	v.Check(toast.Contact != "", "contact", "must be provided")
 	v.Check(len(toast.Contact) <= 200, "contact", "must not be more than 200 bytes long")
 
 	v.Check(toast.Phone != "", "phone", "must be provided")
-	v.Check(validator.Matches(toast.Phone, validator.PhoneRX), "phone", "must be a valid phone number")
+	v.Check(len(toast.Phone) <= 50, "phone", "must not be more than 50 bytes long")
+	// The E.164 form is only set for a number the phone package could read
+	v.Check(toast.PhoneE164 != "", "phone", "must be a valid phone number")
 
 	v.Check(toast.Email != "", "email", "must be provided")
 	v.Check(validator.Matches(toast.Email, validator.EmailRX), "email", "must be a valid email address")
@@ -54,6 +166,29 @@ func ValidateToast(v *validator.Validator, toast *Toast) {
 	v.Check(len(toast.Mode) >= 1, "mode", "must contain at least 1 entry")
 	v.Check(len(toast.Mode) <= 5, "mode", "must contain at most 5 entries")
 	v.Check(validator.Unique(toast.Mode), "mode", "must not contain duplicate entries")
+	for _, mode := range toast.Mode {
+		v.Check(validator.In(mode, vocabulary.Modes...), "mode", fmt.Sprintf("invalid mode %q", mode))
+	}
+
+	v.Check(len(toast.Tags) <= 20, "tags", "must contain at most 20 entries")
+	v.Check(validator.Unique(toast.Tags), "tags", "must not contain duplicate entries")
+	for _, tag := range toast.Tags {
+		v.Check(tag != "", "tags", "must not contain empty entries")
+		v.Check(len(tag) <= 50, "tags", "must not contain entries more than 50 bytes long")
+	}
+
+	validateAttributes(v, toast.Attributes, vocabulary.Attributes)
+
+	// Opening hours are optional
+	if toast.Hours != nil {
+		validateHours(v, toast.Hours)
+	}
+
+	// The external reference is optional, but a source and id go together
+	v.Check(len(toast.ExternalSource) <= 100, "external_source", "must not be more than 100 bytes long")
+	v.Check(toast.ExternalID == "" || toast.ExternalSource != "", "external_source", "must be provided with an external_id")
+	v.Check(len(toast.ExternalID) <= 200, "external_id", "must not be more than 200 bytes long")
+	v.Check(toast.ExternalSource == "" || toast.ExternalID != "", "external_id", "must be provided with an external_source")
 }
 
 // Define a ToastModel which wraps a sql.DB connection pool
@@ -64,9 +199,10 @@ type ToastModel struct {
 // Insert() allows us  to create a new toast
 func (m ToastModel) Insert(toast *Toast) error {
 	query := `
-		INSERT INTO toasts (name, level, contact, phone, email, website, address, mode)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, created_at, version
+		INSERT INTO toasts (name, level, contact, phone, email, website, address, mode,
+		                    external_source, external_id, phone_e164, tags, attributes, hours, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, COALESCE($12::text[], '{}'), $13, $14, $15)
+		RETURNING id, created_at, updated_at, version
 	`
 	// Collect the data fields into a slice
 	args := []interface{}{
@@ -74,26 +210,58 @@ func (m ToastModel) Insert(toast *Toast) error {
 		toast.Contact, toast.Phone,
 		toast.Email, toast.Website,
 		toast.Address, pq.Array(toast.Mode),
+		toast.ExternalSource, toast.ExternalID,
+		toast.PhoneE164, pq.Array(toast.Tags), toast.Attributes,
+		toast.Hours, toast.CreatedBy,
 	}
 	// Create a context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Cleanup to prevent memory leaks
 	defer cancel()
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&toast.ID, &toast.CreatedAt, &toast.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&toast.ID, &toast.CreatedAt, &toast.UpdatedAt, &toast.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "toasts_external_idx"`:
+			return ErrDuplicateExternalID
+		default:
+			return err
+		}
+	}
+	err = setPrimaryContact(ctx, tx, toast)
+	if err != nil {
+		return err
+	}
+	err = recordEvent(ctx, tx, EventToastCreated, toast)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // Get() allows us to retrieve a specific toast
 func (m ToastModel) Get(id int64) (*Toast, error) {
+	return m.GetFields(id, nil)
+}
+
+// GetFields() allows us to retrieve only some of the fields of a specific
+// toast. No fields means every field
+func (m ToastModel) GetFields(id int64, fields []string) (*Toast, error) {
 	// Ensure that there is a valid id
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	// Create the query
-	query := `
-		SELECT id, created_at, name, level, contact, phone, email, website, address, mode, version
+	columns, scan := toastSelection(fields)
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM toasts
 		WHERE id = $1
-	`
+	`, columns)
 	// Declare a Toast variable to hold the returned data
 	var toast Toast
 	// Create a context
@@ -101,19 +269,7 @@ func (m ToastModel) Get(id int64) (*Toast, error) {
 	// Cleanup to prevent memory leaks
 	defer cancel()
 	// Execute the query using QueryRow()
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&toast.ID,
-		&toast.CreatedAt,
-		&toast.Name,
-		&toast.Level,
-		&toast.Contact,
-		&toast.Phone,
-		&toast.Email,
-		&toast.Website,
-		&toast.Address,
-		pq.Array(&toast.Mode),
-		&toast.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(scan(&toast)...)
 	// Handle any errors
 	if err != nil {
 		// Check the type of error
@@ -131,15 +287,45 @@ func (m ToastModel) Get(id int64) (*Toast, error) {
 // Update() allows us to edit/alter a specific toast
 // Optimistic locking (version number)
 func (m ToastModel) Update(toast *Toast) error {
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = m.update(ctx, tx, toast)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// A queryer runs a query on the connection pool or in a transaction
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// The update() method does the work of Update() using q, so it can also be
+// part of a larger transaction. q should be a transaction, which the
+// change's event is recorded in too
+func (m ToastModel) update(ctx context.Context, q queryer, toast *Toast) error {
 	// Create a query
 	query := `
 		UPDATE toasts
 		SET name = $1, level = $2, contact = $3,
 		    phone = $4, email = $5, website = $6,
-			address = $7, mode = $8, version = version + 1
-		WHERE id = $9
-		AND version = $10
-		RETURNING version
+			address = $7, mode = $8, external_source = $9,
+			external_id = $10, phone_e164 = $11, tags = COALESCE($12::text[], '{}'), attributes = $13,
+			hours = $14, updated_at = NOW(), version = version + 1,
+			latitude = CASE WHEN address = $7 THEN latitude END,
+			longitude = CASE WHEN address = $7 THEN longitude END
+		WHERE id = $15
+		AND version = $16
+		RETURNING updated_at, version, latitude, longitude, created_by
 	`
 	args := []interface{}{
 		toast.Name,
@@ -150,79 +336,404 @@ func (m ToastModel) Update(toast *Toast) error {
 		toast.Website,
 		toast.Address,
 		pq.Array(toast.Mode),
+		toast.ExternalSource,
+		toast.ExternalID,
+		toast.PhoneE164,
+		pq.Array(toast.Tags),
+		toast.Attributes,
+		toast.Hours,
 		toast.ID,
 		toast.Version,
 	}
+	// Check for edit conflicts
+	err := q.QueryRowContext(ctx, query, args...).Scan(&toast.UpdatedAt, &toast.Version, &toast.Latitude, &toast.Longitude, &toast.CreatedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "toasts_external_idx"`:
+			return ErrDuplicateExternalID
+		default:
+			return err
+		}
+	}
+	err = setPrimaryContact(ctx, q, toast)
+	if err != nil {
+		return err
+	}
+	return recordEvent(ctx, q, EventToastUpdated, toast)
+}
+
+// GetByExternalID() allows us to retrieve a toast by the id it has in
+// another system
+func (m ToastModel) GetByExternalID(source string, externalID string) (*Toast, error) {
+	// An empty id never identifies a toast
+	if source == "" || externalID == "" {
+		return nil, ErrRecordNotFound
+	}
+	// Create the query
+	columns, scan := toastSelection(nil)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM toasts
+		WHERE external_source = $1
+		AND external_id = $2
+	`, columns)
+	// Declare a Toast variable to hold the returned data
+	var toast Toast
 	// Create a context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Cleanup to prevent memory leaks
 	defer cancel()
-	// Check for edit conflicts
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&toast.Version)
+	// Execute the query using QueryRow()
+	err := m.DB.QueryRowContext(ctx, query, source, externalID).Scan(scan(&toast)...)
+	// Handle any errors
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return nil, ErrRecordNotFound
 		default:
-			return err
+			return nil, err
+		}
+	}
+	// Success
+	return &toast, nil
+}
+
+// AnyVersion is the version Upsert() replaces a stored toast at whatever
+// version it has
+const AnyVersion int32 = -1
+
+// Upsert() creates a toast or replaces the toast with the same external id.
+// It reports whether a new toast was created. With a version of zero it
+// only creates one, though sending a stored toast again as it is succeeds
+// without changing it. Otherwise it only replaces the stored toast at that
+// version, or at any version with AnyVersion, and a missing toast is
+// ErrRecordNotFound
+// Optimistic locking (version number)
+func (m ToastModel) Upsert(toast *Toast, version int32) (bool, error) {
+	// Whether the stored toast already is the one sent
+	const unchanged = `(toasts.name, toasts.level, toasts.contact, toasts.phone, toasts.phone_e164,
+		toasts.email, toasts.website, toasts.address, toasts.mode, toasts.tags, toasts.attributes,
+		toasts.hours) IS NOT DISTINCT FROM (EXCLUDED.name, EXCLUDED.level, EXCLUDED.contact,
+		EXCLUDED.phone, EXCLUDED.phone_e164, EXCLUDED.email, EXCLUDED.website, EXCLUDED.address,
+		EXCLUDED.mode, EXCLUDED.tags, EXCLUDED.attributes, EXCLUDED.hours)`
+	// Create a query. An unchanged toast keeps its version and updated_at,
+	// so updated_at is only NOW() for a toast this statement wrote
+	query := fmt.Sprintf(`
+		INSERT INTO toasts (name, level, contact, phone, email, website, address, mode,
+		                    external_source, external_id, phone_e164, tags, attributes, hours, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, COALESCE($12::text[], '{}'), $13, $14, $16)
+		ON CONFLICT (external_source, external_id) WHERE external_id <> ''
+		DO UPDATE SET name = EXCLUDED.name, level = EXCLUDED.level,
+		    contact = EXCLUDED.contact, phone = EXCLUDED.phone, phone_e164 = EXCLUDED.phone_e164,
+			email = EXCLUDED.email, website = EXCLUDED.website,
+			address = EXCLUDED.address, mode = EXCLUDED.mode,
+			tags = EXCLUDED.tags, attributes = EXCLUDED.attributes, hours = EXCLUDED.hours,
+			updated_at = CASE WHEN %[1]s THEN toasts.updated_at ELSE NOW() END,
+			version = CASE WHEN %[1]s THEN toasts.version ELSE toasts.version + 1 END,
+			latitude = CASE WHEN toasts.address = EXCLUDED.address THEN toasts.latitude END,
+			longitude = CASE WHEN toasts.address = EXCLUDED.address THEN toasts.longitude END
+		WHERE toasts.version = $15 OR $15 = -1 OR ($15 = 0 AND %[1]s)
+		RETURNING id, created_at, updated_at, version, latitude, longitude, created_by,
+		          xmax = 0, updated_at = NOW()
+	`, unchanged)
+	args := []interface{}{
+		toast.Name, toast.Level,
+		toast.Contact, toast.Phone,
+		toast.Email, toast.Website,
+		toast.Address, pq.Array(toast.Mode),
+		toast.ExternalSource, toast.ExternalID,
+		toast.PhoneE164, pq.Array(toast.Tags), toast.Attributes,
+		toast.Hours, version, toast.CreatedBy,
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	// The xmax system column is only zero for a freshly inserted row. No
+	// row at all means the WHERE clause rejected the version
+	var created, changed bool
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&toast.ID, &toast.CreatedAt, &toast.UpdatedAt, &toast.Version,
+		&toast.Latitude, &toast.Longitude, &toast.CreatedBy, &created, &changed)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrEditConflict
+		default:
+			return false, err
+		}
+	}
+	// A replacement needs a stored toast. Rolling back undoes the insert
+	if created && version != 0 {
+		return false, ErrRecordNotFound
+	}
+	if !changed {
+		return false, tx.Commit()
+	}
+	err = setPrimaryContact(ctx, tx, toast)
+	if err != nil {
+		return false, err
+	}
+	event := EventToastUpdated
+	if created {
+		event = EventToastCreated
+	}
+	err = recordEvent(ctx, tx, event, toast)
+	if err != nil {
+		return false, err
+	}
+	return created, tx.Commit()
+}
+
+// SetLocation() stores the geocoded location of a toast. The location is
+// only stored if the toast still has the address that was geocoded. The
+// toast's representation changes, so it gets a new version
+func (m ToastModel) SetLocation(id int64, address string, latitude float64, longitude float64) error {
+	query := `
+		UPDATE toasts
+		SET latitude = $1, longitude = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3
+		AND address = $4
+		RETURNING %s
+	`
+	return m.set(query, latitude, longitude, id, address)
+}
+
+// GetUnnormalizedPhones() returns up to limit toasts, after the toast with
+// id afterID, whose phone numbers have no E.164 form yet. Only the id and
+// phone are read
+func (m ToastModel) GetUnnormalizedPhones(afterID int64, limit int) ([]*Toast, error) {
+	query := `
+		SELECT id, phone
+		FROM toasts
+		WHERE phone_e164 = ''
+		AND id > $1
+		ORDER BY id ASC
+		LIMIT $2
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	toasts := []*Toast{}
+	for rows.Next() {
+		var toast Toast
+		err := rows.Scan(&toast.ID, &toast.Phone)
+		if err != nil {
+			return nil, err
 		}
+		toasts = append(toasts, &toast)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
-	return nil
+	return toasts, nil
+}
+
+// SetPhoneE164() stores the E.164 form of a toast's phone number next to
+// the number as it was typed. It is only stored if the toast still has the
+// number that was read. The toast's representation changes, so it gets a
+// new version
+func (m ToastModel) SetPhoneE164(id int64, phone string, e164 string) error {
+	query := `
+		UPDATE toasts
+		SET phone_e164 = $1, updated_at = NOW(), version = version + 1
+		WHERE id = $2
+		AND phone = $3
+		RETURNING %s
+	`
+	return m.set(query, e164, id, phone)
+}
+
+// The set() method runs an update that fills in a toast's derived columns
+// and records the change when the update matched the toast. The %s in the
+// query is replaced by the toast's columns
+func (m ToastModel) set(query string, args ...interface{}) error {
+	columns, scan := toastSelection(nil)
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var toast Toast
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(query, columns), args...).Scan(scan(&toast)...)
+	if err != nil {
+		// The toast has changed since, so there is nothing to do
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	err = recordEvent(ctx, tx, EventToastUpdated, &toast)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // Delete() removes a specific toast
-func (m ToastModel) Delete(id int64) error {
+// Optimistic locking (version number)
+func (m ToastModel) Delete(id int64, version int32) error {
 	// Ensure that there is a valid id
 	if id < 1 {
 		return ErrRecordNotFound
 	}
-	// Create the delete query
-	query := `
-			DELETE FROM toasts
-			WHERE id = $1
-		`
 	// Create a context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Cleanup to prevent memory leaks
 	defer cancel()
-	// Execute the query
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	// Check how many rows were affected by the delete operation. We
-	// call the RowsAffected() method on the result variable
-	rowsAffected, err := result.RowsAffected()
+	defer tx.Rollback()
+	err = m.delete(ctx, tx, id, version)
 	if err != nil {
 		return err
 	}
-	// Check if no rows were affected
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	return tx.Commit()
+}
+
+// The delete() method does the work of Delete() using q, so it can also be
+// part of a larger transaction. A version of zero removes whatever is
+// stored. The removed toast is kept in the change's event
+func (m ToastModel) delete(ctx context.Context, q queryer, id int64, version int32) error {
+	// Create the delete query
+	columns, scan := toastSelection(nil)
+	query := fmt.Sprintf(`
+		DELETE FROM toasts
+		WHERE id = $1
+		AND ($2 = 0 OR version = $2)
+		RETURNING %s
+	`, columns)
+	var toast Toast
+	err := q.QueryRowContext(ctx, query, id, version).Scan(scan(&toast)...)
+	// No row means the record was either removed or changed by someone else
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return recordEvent(ctx, q, EventToastDeleted, &toast)
+}
+
+// The condition a toast must meet to match a ToastSearch. Its placeholders
+// are filled by the search's args(). Names and text searches also match the
+// toast's translations
+const toastSearchCondition = `(to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = ''
+		     OR EXISTS (SELECT 1 FROM toast_translations AS t WHERE t.toast_id = toasts.id
+		                AND to_tsvector('simple', t.name) @@ plainto_tsquery('simple', $1)))
+		AND (to_tsvector('simple', level) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		AND (mode @> $3 OR $3 = '{}' )
+		AND (search @@ websearch_to_tsquery('simple', $4) OR $4 = ''
+		     OR EXISTS (SELECT 1 FROM toast_translations AS t WHERE t.toast_id = toasts.id
+		                AND t.search @@ websearch_to_tsquery('simple', $4)))
+		AND (name % $5 OR $5 = ''
+		     OR EXISTS (SELECT 1 FROM toast_translations AS t WHERE t.toast_id = toasts.id AND t.name % $5))
+		AND (NOT $6 OR (earth_box(ll_to_earth($7, $8), $9) @> ll_to_earth(latitude, longitude)
+		                AND earth_distance(ll_to_earth($7, $8), ll_to_earth(latitude, longitude)) <= $9))
+		AND (phone_e164 = $10 OR $10 = '')
+		AND ($11::timestamp with time zone IS NULL OR toast_is_open(hours, $11))
+		AND (tags @> $12 OR $12 = '{}')`
+
+// The args() method returns the values of the placeholders $1 to $12 in
+// toastSearchCondition
+func (search ToastSearch) args() []interface{} {
+	return []interface{}{
+		search.Name, search.Level, pq.Array(search.Mode), search.Search, search.NameFuzzy,
+		search.Near, search.Latitude, search.Longitude, search.RadiusKm * 1000, search.Phone,
+		search.OpenAt, pq.Array(search.Tags),
+	}
+}
+
+// The beginSearch() method starts the transaction a search runs in. The %
+// operator uses the pg_trgm.similarity_threshold setting, which we set for
+// this transaction only
+func (m ToastModel) beginSearch(ctx context.Context, search ToastSearch) (*sql.Tx, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if search.NameFuzzy != "" {
+		_, err = tx.ExecContext(ctx, `SELECT set_config('pg_trgm.similarity_threshold', $1, true)`,
+			strconv.FormatFloat(search.Similarity, 'f', -1, 64))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
 }
 
-// The GetAll() method retuns a list of all the toasts sorted by id
-func (m ToastModel) GetAll(name string, level string, mode []string, filters Filters) ([]*Toast, Metadata, error) {
+// The GetAll() method retuns a list of all the toasts matching the search
+// sorted by id
+func (m ToastModel) GetAll(search ToastSearch, filters Filters) ([]*Toast, Metadata, error) {
+	// Sorting by relevance orders by how well the search and fuzzy name match
+	// and sorting by distance by how far away the toast is. Otherwise each
+	// row carries the text of its sort values for the cursors
+	orderBy, cursorValues := filters.orderBy(), filters.cursorColumns()
+	switch filters.Sort {
+	case "relevance":
+		orderBy = "ts_rank(search, websearch_to_tsquery('simple', $4)) + similarity(name, $5) DESC, id ASC"
+		cursorValues = "ARRAY[]::text[]"
+	case "distance":
+		orderBy = "earth_distance(ll_to_earth($7, $8), ll_to_earth(latitude, longitude)) ASC, id ASC"
+		cursorValues = "ARRAY[]::text[]"
+	}
+	// Only the rows beyond the cursor, if there is one
+	args := search.args()
+	keyset, keysetArgs := filters.keyset(len(args) + 1)
+	args = append(args, keysetArgs...)
+	// The filter expression, if there is one
+	filter, filterArgs := filters.filterCondition(len(args) + 1)
+	args = append(args, filterArgs...)
+	// Read one extra row to find out if there is another page
+	args = append(args, filters.limit()+1, filters.offset())
+	// Only read the columns for the fields the client asked for
+	columns, scan := toastSelection(filters.Fields)
 	// Construct the query
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, created_at, name, level,
-		       contact, phone, email, website,
-			   address, mode, version
+		SELECT COUNT(*) OVER(), %s, %s,
+			   CASE WHEN $4 = '' THEN ''
+			   ELSE ts_headline('simple', concat_ws(' | ', name, level, contact, address, website),
+			                    websearch_to_tsquery('simple', $4))
+			   END,
+			   CASE WHEN $6 THEN earth_distance(ll_to_earth($7, $8), ll_to_earth(latitude, longitude)) / 1000 END
 		FROM toasts
-		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (to_tsvector('simple', level) @@ plainto_tsquery('simple', $2) OR $2 = '')
-		AND (mode @> $3 OR $3 = '{}' )
-		ORDER BY %s %s, id ASC
-		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortOrder())
+		WHERE %s
+		AND %s
+		AND %s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d`, cursorValues, columns, toastSearchCondition, keyset, filter, orderBy, len(args)-1, len(args))
 
 	// Create a 3-second-timout context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
+	tx, err := m.beginSearch(ctx, search)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer tx.Rollback()
 	// Execute the query
-	args := []interface{}{name, level, pq.Array(mode), filters.limit(), filters.offset()}
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -231,34 +742,86 @@ func (m ToastModel) GetAll(name string, level string, mode []string, filters Fil
 	totalRecords := 0
 	// Initialize an empty slice to hold the Toast data
 	toasts := []*Toast{}
+	cursors := []cursor{}
 	// Iterate over the rows in the resultset
 	for rows.Next() {
 		var toast Toast
+		var c cursor
 		// Scan the values from the row into toast
-		err := rows.Scan(
-			&totalRecords,
-			&toast.ID,
-			&toast.CreatedAt,
-			&toast.Name,
-			&toast.Level,
-			&toast.Contact,
-			&toast.Phone,
-			&toast.Email,
-			&toast.Website,
-			&toast.Address,
-			pq.Array(&toast.Mode),
-			&toast.Version,
-		)
+		dests := []interface{}{&totalRecords, pq.Array(&c.Values)}
+		dests = append(dests, scan(&toast)...)
+		dests = append(dests, &toast.Snippet, &toast.DistanceKm)
+		err := rows.Scan(dests...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 		// Add the Toast to our slice
+		c.ID = toast.ID
 		toasts = append(toasts, &toast)
+		cursors = append(cursors, c)
 	}
 	// Check for errors after looping through the resultset
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
+	// Drop the extra row and put the rows read backwards back in order
+	more := len(toasts) > filters.limit()
+	if more {
+		toasts, cursors = toasts[:filters.limit()], cursors[:filters.limit()]
+	}
+	if filters.Before != "" {
+		for i, j := 0, len(toasts)-1; i < j; i, j = i+1, j-1 {
+			toasts[i], toasts[j] = toasts[j], toasts[i]
+			cursors[i], cursors[j] = cursors[j], cursors[i]
+		}
+	}
+	// Create the pagination metadata. The count only covers the rows beyond
+	// a cursor so it is left out when paging with cursors
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	if filters.usesCursor() {
+		metadata = Metadata{PageSize: filters.PageSize}
+	}
+	if len(toasts) > 0 && filters.Sort != "relevance" && filters.Sort != "distance" {
+		metadata = filters.calculateCursorMetadata(metadata, cursors[0], cursors[len(cursors)-1], more)
+	}
 	// Return the slice of Toasts
-	return nil, Metadata{}, err
+	return toasts, metadata, nil
+}
+
+// The Suggest() method returns up to limit distinct toast names starting
+// with prefix, closest matches first. It backs autocomplete so it only
+// touches the name column
+func (m ToastModel) Suggest(prefix string, limit int) ([]string, error) {
+	// Escape the LIKE wildcards in the prefix
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+	// Construct the query
+	query := `
+		SELECT name
+		FROM toasts
+		WHERE name ILIKE $1
+		GROUP BY name
+		ORDER BY similarity(name, $2) DESC, name ASC
+		LIMIT $3
+	`
+	// Create a 3-second-timout context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pattern, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
 }
@@ -0,0 +1,192 @@
+// Filename: internal/data/translations.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"toaster.jalen.net/internals/validator"
+)
+
+// Locales are lower case BCP 47 language tags, such as "es" or "pt-br"
+var LocaleRX = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// A Translation is the name and address of a toast in another language.
+// An empty address means the toast's own address is used
+type Translation struct {
+	ToastID   int64     `json:"toast_id"`
+	Locale    string    `json:"locale"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// ValidateTranslation() checks a translation. Locales lists the languages
+// toasts can be translated into
+func ValidateTranslation(v *validator.Validator, translation *Translation, locales []string) {
+	v.Check(validator.Matches(translation.Locale, LocaleRX), "locale", "must be a valid language tag")
+	v.Check(validator.In(translation.Locale, locales...), "locale", "must be one of the supported languages")
+
+	v.Check(translation.Name != "", "name", "must be provided")
+	v.Check(len(translation.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(translation.Address) <= 500, "address", "must not be more than 500 bytes long")
+}
+
+// Define a TranslationModel which wraps a sql.DB connection pool
+type TranslationModel struct {
+	DB *sql.DB
+}
+
+// GetAllForToast() returns the translations of a toast, sorted by locale
+func (m TranslationModel) GetAllForToast(toastID int64) ([]*Translation, error) {
+	query := `
+		SELECT toast_id, locale, created_at, updated_at, name, address, version
+		FROM toast_translations
+		WHERE toast_id = $1
+		ORDER BY locale ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, toastID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	translations := []*Translation{}
+	for rows.Next() {
+		var translation Translation
+		err := rows.Scan(&translation.ToastID, &translation.Locale, &translation.CreatedAt,
+			&translation.UpdatedAt, &translation.Name, &translation.Address, &translation.Version)
+		if err != nil {
+			return nil, err
+		}
+		translations = append(translations, &translation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return translations, nil
+}
+
+// Put() creates a translation or replaces the toast's translation into the
+// same locale. It reports whether a new translation was created
+func (m TranslationModel) Put(translation *Translation) (bool, error) {
+	query := `
+		INSERT INTO toast_translations (toast_id, locale, name, address)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (toast_id, locale)
+		DO UPDATE SET name = EXCLUDED.name, address = EXCLUDED.address,
+		    updated_at = NOW(), version = toast_translations.version + 1
+		RETURNING created_at, updated_at, version, xmax = 0
+	`
+	args := []interface{}{translation.ToastID, translation.Locale, translation.Name, translation.Address}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	// The xmax system column is only zero for a freshly inserted row
+	var created bool
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&translation.CreatedAt, &translation.UpdatedAt,
+		&translation.Version, &created)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "toast_translations" violates foreign key constraint "toast_translations_toast_id_fkey"`:
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+	return created, nil
+}
+
+// Delete() removes a toast's translation into a locale
+func (m TranslationModel) Delete(toastID int64, locale string) error {
+	query := `
+		DELETE FROM toast_translations
+		WHERE toast_id = $1
+		AND locale = $2
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, toastID, locale)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Translate() replaces the names and addresses of the toasts with their
+// translations into the locale, when every one of them has a translation.
+// Otherwise all of them keep their own, so they stay in one language. A
+// translation without an address keeps the toast's. It reports whether
+// the toasts were translated
+func (m TranslationModel) Translate(toasts []*Toast, locale string) (bool, error) {
+	if len(toasts) == 0 {
+		return true, nil
+	}
+	ids := make([]int64, len(toasts))
+	for i, toast := range toasts {
+		ids[i] = toast.ID
+	}
+	query := `
+		SELECT toast_id, name, address
+		FROM toast_translations
+		WHERE toast_id = ANY($1)
+		AND locale = $2
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), locale)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	// Read all the translations before changing any toast
+	type translation struct{ name, address string }
+	byID := make(map[int64]translation, len(toasts))
+	for rows.Next() {
+		var id int64
+		var t translation
+		err := rows.Scan(&id, &t.name, &t.address)
+		if err != nil {
+			return false, err
+		}
+		byID[id] = t
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	for _, toast := range toasts {
+		if _, ok := byID[toast.ID]; !ok {
+			return false, nil
+		}
+	}
+	for _, toast := range toasts {
+		t := byID[toast.ID]
+		toast.Name = t.name
+		if t.address != "" {
+			toast.Address = t.address
+		}
+	}
+	return true, nil
+}
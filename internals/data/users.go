@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -129,6 +130,77 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Get() returns the user with the given id
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// GetAll() returns a page of the users matching the filter expression
+func (m UserModel) GetAll(filters Filters) ([]*User, Metadata, error) {
+	filter, args := filters.filterCondition(1)
+	args = append(args, filters.limit(), filters.offset())
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, name, email, activated, version
+		FROM users
+		WHERE %s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d
+	`, filter, filters.orderBy(), len(args)-1, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&totalRecords, &user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Activated, &user.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
 // Get user based on their email
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
@@ -0,0 +1,185 @@
+// Filename: internal/data/vocabularies.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"toaster.jalen.net/internals/validator"
+)
+
+var (
+	ErrDuplicateTerm = errors.New("duplicate term")
+	ErrTermInUse     = errors.New("term in use")
+)
+
+// A Vocabulary holds the levels and modes a toast may use, and the schemas
+// of its custom attributes
+type Vocabulary struct {
+	Levels     []string          `json:"levels"`
+	Modes      []string          `json:"modes"`
+	Attributes []AttributeSchema `json:"attributes"`
+}
+
+// The vocabularies a toast uses. Each lookup table has a name column and
+// the toasts column its terms are used in
+var (
+	LevelVocabulary = VocabularyTable{table: "toast_levels", used: "level = $1"}
+	ModeVocabulary  = VocabularyTable{table: "toast_modes", used: "$1 = ANY(mode)"}
+)
+
+// A VocabularyTable names a lookup table and how toasts use its terms. Its
+// fields can only be set in this package, so they are safe to use in queries
+type VocabularyTable struct {
+	table string
+	used  string
+}
+
+// The Canonicalize() method replaces the level and modes of a toast with
+// the terms they match when case and extra whitespace are ignored. Values
+// matching no term are left for ValidateToast() to report
+func (vocabulary Vocabulary) Canonicalize(toast *Toast) {
+	toast.Level = canonicalTerm(toast.Level, vocabulary.Levels)
+	for i := range toast.Mode {
+		toast.Mode[i] = canonicalTerm(toast.Mode[i], vocabulary.Modes)
+	}
+}
+
+// The canonicalTerm() function returns the term that value matches, or
+// value itself if there is none
+func canonicalTerm(value string, terms []string) string {
+	key := termKey(value)
+	for _, term := range terms {
+		if termKey(term) == key {
+			return term
+		}
+	}
+	return value
+}
+
+// The termKey() function ignores case and extra whitespace in a term
+func termKey(term string) string {
+	return strings.Join(strings.Fields(strings.ToLower(term)), " ")
+}
+
+func ValidateTerm(v *validator.Validator, term string) {
+	v.Check(strings.TrimSpace(term) != "", "name", "must be provided")
+	v.Check(len(term) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(term == strings.Join(strings.Fields(term), " "), "name", "must not contain extra whitespace")
+}
+
+// Define a VocabularyModel which wraps a sql.DB connection pool
+type VocabularyModel struct {
+	DB *sql.DB
+}
+
+// Get() returns the levels, modes and attribute schemas, sorted by name
+func (m VocabularyModel) Get() (Vocabulary, error) {
+	var vocabulary Vocabulary
+	var err error
+	vocabulary.Levels, err = m.GetTerms(LevelVocabulary)
+	if err != nil {
+		return Vocabulary{}, err
+	}
+	vocabulary.Modes, err = m.GetTerms(ModeVocabulary)
+	if err != nil {
+		return Vocabulary{}, err
+	}
+	vocabulary.Attributes, err = AttributeModel{DB: m.DB}.GetAll()
+	if err != nil {
+		return Vocabulary{}, err
+	}
+	return vocabulary, nil
+}
+
+// GetTerms() returns the terms of a vocabulary, sorted by name
+func (m VocabularyModel) GetTerms(vocabulary VocabularyTable) ([]string, error) {
+	query := fmt.Sprintf(`
+		SELECT name
+		FROM %s
+		ORDER BY lower(name) ASC
+	`, vocabulary.table)
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	terms := []string{}
+	for rows.Next() {
+		var term string
+		err := rows.Scan(&term)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+// InsertTerm() adds a term to a vocabulary. Terms differing only in case
+// are duplicates
+func (m VocabularyModel) InsertTerm(vocabulary VocabularyTable, term string) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s (name)
+		VALUES ($1)
+	`, vocabulary.table)
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, term)
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), "pq: duplicate key value violates unique constraint"):
+			return ErrDuplicateTerm
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTerm() removes a term from a vocabulary. A term that toasts still
+// use can't be removed
+func (m VocabularyModel) DeleteTerm(vocabulary VocabularyTable, term string) error {
+	query := fmt.Sprintf(`
+		WITH used AS (
+			SELECT EXISTS (SELECT 1 FROM toasts WHERE %s) AS used
+		), deleted AS (
+			DELETE FROM %s
+			WHERE name = $1
+			AND NOT (SELECT used FROM used)
+			RETURNING name
+		)
+		SELECT (SELECT used FROM used), EXISTS (SELECT 1 FROM %s WHERE name = $1)
+	`, vocabulary.used, vocabulary.table, vocabulary.table)
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	// The final SELECT sees the table as it was before the delete
+	var used, exists bool
+	err := m.DB.QueryRowContext(ctx, query, term).Scan(&used, &exists)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !exists:
+		return ErrRecordNotFound
+	case used:
+		return ErrTermInUse
+	}
+	return nil
+}
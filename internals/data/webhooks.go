@@ -0,0 +1,362 @@
+// Filename: internal/data/webhooks.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"toaster.jalen.net/internals/validator"
+)
+
+// The states of a delivery
+var DeliveryStatuses = []string{"pending", "succeeded", "failed"}
+
+// A Webhook is a URL toast events are posted to, signed with its secret.
+// No events means all of them. The secret is only shown when it is set
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 bytes long")
+	v.Check(len(webhook.Secret) <= 200, "secret", "must not be more than 200 bytes long")
+
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate entries")
+	for _, event := range webhook.Events {
+		v.Check(validator.In(event, EventTypes...), "events", "must only contain toast.created, toast.updated and toast.deleted")
+	}
+}
+
+// A Delivery is the sending of an event to a webhook, with the result of
+// its latest attempt. The URL, secret and event are read for sending only
+type Delivery struct {
+	ID             int64      `json:"id"`
+	WebhookID      int64      `json:"webhook_id"`
+	EventID        int64      `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	URL            string     `json:"-"`
+	Secret         string     `json:"-"`
+	Event          Event      `json:"-"`
+}
+
+// Define a WebhookModel which wraps a sql.DB connection pool
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert() registers a webhook
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, events, active)
+		VALUES ($1, $2, COALESCE($3::text[], '{}'), $4)
+		RETURNING id, created_at, version
+	`
+	args := []interface{}{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+// Get() returns a webhook, secret included
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	// Ensure that there is a valid id
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, url, secret, events, active, version
+		FROM webhooks
+		WHERE id = $1
+	`
+	var webhook Webhook
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.URL,
+		&webhook.Secret, pq.Array(&webhook.Events), &webhook.Active, &webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &webhook, nil
+}
+
+// GetAll() returns the webhooks in the order they were registered, without
+// their secrets
+func (m WebhookModel) GetAll() ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, events, active, version
+		FROM webhooks
+		ORDER BY id ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(&webhook.ID, &webhook.CreatedAt, &webhook.URL, pq.Array(&webhook.Events),
+			&webhook.Active, &webhook.Version)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// Update() edits a webhook. Deliveries already queued are still sent
+// Optimistic locking (version number)
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, secret = $2, events = COALESCE($3::text[], '{}'), active = $4, version = version + 1
+		WHERE id = $5
+		AND version = $6
+		RETURNING version
+	`
+	args := []interface{}{
+		webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active,
+		webhook.ID, webhook.Version,
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	// Check for edit conflicts
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete() removes a webhook along with its deliveries
+func (m WebhookModel) Delete(id int64) error {
+	// Ensure that there is a valid id
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetDeliveries() returns a page of a webhook's deliveries, the latest
+// first. An empty status means deliveries in any state
+func (m WebhookModel) GetDeliveries(webhookID int64, status string, filters Filters) ([]*Delivery, Metadata, error) {
+	query := `
+		SELECT COUNT(*) OVER(), d.id, d.webhook_id, d.event_id, e.type, d.created_at, d.status, d.attempts,
+		       CASE WHEN d.status = 'pending' THEN d.next_attempt_at END,
+		       d.last_status_code, d.last_error, d.delivered_at
+		FROM webhook_deliveries AS d
+		INNER JOIN toast_events AS e
+		ON e.id = d.event_id
+		WHERE d.webhook_id = $1
+		AND (d.status = $2 OR $2 = '')
+		ORDER BY d.id DESC
+		LIMIT $3 OFFSET $4
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		var delivery Delivery
+		err := rows.Scan(&totalRecords, &delivery.ID, &delivery.WebhookID, &delivery.EventID,
+			&delivery.EventType, &delivery.CreatedAt, &delivery.Status, &delivery.Attempts,
+			&delivery.NextAttemptAt, &delivery.LastStatusCode, &delivery.LastError, &delivery.DeliveredAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return deliveries, metadata, nil
+}
+
+// ClaimDeliveries() takes up to limit pending deliveries that are due and
+// counts an attempt for each. They aren't due again until lease has passed,
+// so a delivery whose sender stops is picked up again later, and several
+// senders never take the same delivery
+func (m WebhookModel) ClaimDeliveries(limit int, lease time.Duration) ([]*Delivery, error) {
+	query := `
+		WITH due AS (
+			SELECT id
+			FROM webhook_deliveries
+			WHERE status = 'pending'
+			AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at ASC, id ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		), claimed AS (
+			UPDATE webhook_deliveries AS d
+			SET attempts = d.attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+			FROM due
+			WHERE d.id = due.id
+			RETURNING d.id, d.webhook_id, d.event_id, d.created_at, d.attempts
+		)
+		SELECT c.id, c.webhook_id, c.event_id, c.created_at, c.attempts, w.url, w.secret,
+		       e.type, e.created_at, e.toast_id, e.payload
+		FROM claimed AS c
+		INNER JOIN webhooks AS w
+		ON w.id = c.webhook_id
+		INNER JOIN toast_events AS e
+		ON e.id = c.event_id
+		ORDER BY c.id ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		var delivery Delivery
+		// Scanning into a []byte copies the payload out of the driver's buffer
+		var payload []byte
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.CreatedAt,
+			&delivery.Attempts, &delivery.URL, &delivery.Secret, &delivery.Event.Type,
+			&delivery.Event.CreatedAt, &delivery.Event.ToastID, &payload)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Event.Toast = payload
+		delivery.Status = "pending"
+		delivery.Event.ID = delivery.EventID
+		delivery.EventType = delivery.Event.Type
+		deliveries = append(deliveries, &delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// FinishAttempt() records the result of an attempt at a claimed delivery.
+// A delivery that isn't done is tried again after retryAfter
+func (m WebhookModel) FinishAttempt(delivery *Delivery, retryAfter time.Duration) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, last_status_code = $2, last_error = $3,
+		    next_attempt_at = NOW() + make_interval(secs => $4),
+		    delivered_at = CASE WHEN $1 = 'succeeded' THEN NOW() END
+		WHERE id = $5
+	`
+	args := []interface{}{
+		delivery.Status, delivery.LastStatusCode, delivery.LastError,
+		retryAfter.Seconds(), delivery.ID,
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RetryDelivery() makes a webhook's delivery due again, with a fresh count
+// of attempts, whatever its state
+func (m WebhookModel) RetryDelivery(webhookID int64, id int64) error {
+	// Ensure that there is a valid id
+	if webhookID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), delivered_at = NULL
+		WHERE webhook_id = $1
+		AND id = $2
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, webhookID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
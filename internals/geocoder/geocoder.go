@@ -0,0 +1,66 @@
+// Filename: internal/geocoder/geocoder.go
+
+package geocoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+var (
+	ErrNotFound = errors.New("address not found")
+)
+
+// A Location is a point on the earth in decimal degrees
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// A Geocoder turns a free text address into a location
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (Location, error)
+}
+
+// File is a Geocoder that looks addresses up in a local JSON file of the
+// form {"address": {"latitude": 0, "longitude": 0}}. It stands in for a
+// geocoding service in development and tests
+type File struct {
+	locations map[string]Location
+}
+
+// NewFile() reads the addresses from the JSON file at path
+func NewFile(path string) (*File, error) {
+	js, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var locations map[string]Location
+	err = json.Unmarshal(js, &locations)
+	if err != nil {
+		return nil, err
+	}
+	// Store the addresses in the form we look them up in
+	f := &File{locations: make(map[string]Location, len(locations))}
+	for address, location := range locations {
+		f.locations[normalize(address)] = location
+	}
+	return f, nil
+}
+
+// Geocode() returns the location of an address in the file
+func (f *File) Geocode(ctx context.Context, address string) (Location, error) {
+	location, ok := f.locations[normalize(address)]
+	if !ok {
+		return Location{}, ErrNotFound
+	}
+	return location, nil
+}
+
+// The normalize() function ignores case and extra whitespace in an address
+func normalize(address string) string {
+	return strings.Join(strings.Fields(strings.ToLower(address)), " ")
+}
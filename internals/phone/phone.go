@@ -0,0 +1,39 @@
+// Filename: internal/phone/phone.go
+
+package phone
+
+import (
+	"errors"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+var (
+	ErrInvalid = errors.New("invalid phone number")
+)
+
+// A Number is a phone number in its canonical E.164 form, used for storage
+// and lookups, and in its international display form
+type Number struct {
+	E164    string
+	Display string
+}
+
+// Parse() reads a phone number in any common format. Numbers without a
+// country code are read as numbers in region, an ISO 3166 country code
+// such as "US"
+func Parse(number string, region string) (Number, error) {
+	parsed, err := phonenumbers.Parse(number, region)
+	if err != nil || !phonenumbers.IsValidNumber(parsed) {
+		return Number{}, ErrInvalid
+	}
+	return Number{
+		E164:    phonenumbers.Format(parsed, phonenumbers.E164),
+		Display: phonenumbers.Format(parsed, phonenumbers.INTERNATIONAL),
+	}, nil
+}
+
+// ValidRegion() checks that region is a country phone numbers can be read in
+func ValidRegion(region string) bool {
+	return phonenumbers.GetCountryCodeForRegion(region) != 0
+}
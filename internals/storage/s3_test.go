@@ -0,0 +1,203 @@
+// Filename: internal/storage/s3_test.go
+
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "us-east-1"
+	testBucket    = "toaster"
+)
+
+// A fakeS3 is an S3-compatible store that keeps objects in memory. It
+// refuses requests that aren't signed with the test keys
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3(t *testing.T) (*S3, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{t: t, objects: map[string][]byte{}, types: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	s, err := NewS3(srv.URL, testRegion, testBucket, testAccessKey, testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, fake
+}
+
+// The object() method returns the stored object and its content type
+func (f *fakeS3) object(key string) ([]byte, string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, ok := f.objects[key]
+	return body, f.types[key], ok
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := verifySignature(r); err != nil {
+		f.t.Errorf("%s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+testBucket+"/") {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+testBucket+"/")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) != r.ContentLength {
+			http.Error(w, "IncompleteBody", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", f.types[key])
+		w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// The verifySignature() function works out the Signature Version 4 of the
+// request the way S3 does and compares it with the one it was sent with
+func verifySignature(r *http.Request) error {
+	amzDate := r.Header.Get("X-Amz-Date")
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if len(amzDate) != len("20060102T150405Z") || payload == "" {
+		return errors.New("missing X-Amz-Date or X-Amz-Content-Sha256")
+	}
+	date := amzDate[:8]
+	scope := date + "/" + testRegion + "/s3/aws4_request"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := r.Method + "\n" +
+		r.URL.EscapedPath() + "\n" +
+		r.URL.RawQuery + "\n" +
+		"host:" + r.Host + "\n" +
+		"x-amz-content-sha256:" + payload + "\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"\n" +
+		signedHeaders + "\n" +
+		payload
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+testSecretKey), date)
+	key = mac(key, testRegion)
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	want := "AWS4-HMAC-SHA256 Credential=" + testAccessKey + "/" + scope +
+		", SignedHeaders=" + signedHeaders +
+		", Signature=" + hex.EncodeToString(mac(key, stringToSign))
+	if got := r.Header.Get("Authorization"); got != want {
+		return errors.New("Authorization is " + got + ", want " + want)
+	}
+	return nil
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	s, fake := newFakeS3(t)
+	ctx := context.Background()
+	key := "toasts/7/prospectus.pdf"
+
+	err := s.Put(ctx, key, strings.NewReader("%PDF-1.7 prospectus"), "application/pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, got, _ := fake.object(key); got != "application/pdf" {
+		t.Errorf("stored content type %q, want %q", got, "application/pdf")
+	}
+
+	body, err := s.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "%PDF-1.7 prospectus" {
+		t.Errorf("read %q, want %q", contents, "%PDF-1.7 prospectus")
+	}
+
+	err = s.Delete(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := fake.object(key); ok {
+		t.Error("object still stored after Delete()")
+	}
+	_, err = s.Open(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() after Delete() returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3OpenMissing(t *testing.T) {
+	s, _ := newFakeS3(t)
+
+	// S3 answers 404 for a missing object
+	_, err := s.Open(context.Background(), "toasts/7/missing.pdf")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3DeleteMissing(t *testing.T) {
+	s, _ := newFakeS3(t)
+
+	// Removing what isn't there is not an error
+	err := s.Delete(context.Background(), "toasts/7/missing.pdf")
+	if err != nil {
+		t.Errorf("Delete() returned %v, want nil", err)
+	}
+}
+
+func TestS3InvalidKey(t *testing.T) {
+	s, _ := newFakeS3(t)
+
+	_, err := s.Open(context.Background(), "../secrets")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Open() returned %v, want ErrInvalidKey", err)
+	}
+}
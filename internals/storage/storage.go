@@ -0,0 +1,34 @@
+// Filename: internal/storage/storage.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// Keys are slash separated paths of letters, digits, dots, dashes and
+// underscores, so they are safe as file paths and in URLs
+var keyRX = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+
+// A Storage keeps the contents of files under keys
+type Storage interface {
+	// Put() stores everything read from r under key
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Open() returns the contents stored under key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete() removes the contents stored under key, if there are any
+	Delete(ctx context.Context, key string) error
+}
+
+// The validKey() function reports whether key can be used with a Storage
+func validKey(key string) bool {
+	return len(key) <= 1024 && keyRX.MatchString(key)
+}
@@ -0,0 +1,368 @@
+// Filename: proto/toaster.proto
+//
+// The gRPC API. It serves the same toasts as the JSON API, with the same
+// validation and permissions. Calls other than CreateAuthenticationToken
+// send the token in the "authorization" metadata as "Bearer <token>"
+//
+// Regenerate internals/toasterpb after editing this file with:
+//
+//   protoc --proto_path=proto --go_out=. --go_opt=module=toaster.jalen.net \
+//     --go-grpc_out=. --go-grpc_opt=module=toaster.jalen.net proto/toaster.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: toaster.proto
+
+package toasterpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ToastService_GetToast_FullMethodName    = "/toaster.v1.ToastService/GetToast"
+	ToastService_ListToasts_FullMethodName  = "/toaster.v1.ToastService/ListToasts"
+	ToastService_CreateToast_FullMethodName = "/toaster.v1.ToastService/CreateToast"
+	ToastService_UpdateToast_FullMethodName = "/toaster.v1.ToastService/UpdateToast"
+	ToastService_DeleteToast_FullMethodName = "/toaster.v1.ToastService/DeleteToast"
+)
+
+// ToastServiceClient is the client API for ToastService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ToastServiceClient interface {
+	// Needs the toasts:read permission
+	GetToast(ctx context.Context, in *GetToastRequest, opts ...grpc.CallOption) (*Toast, error)
+	// Takes the parameters of "GET /v1/toasts". Needs toasts:read
+	ListToasts(ctx context.Context, in *ListToastsRequest, opts ...grpc.CallOption) (*ListToastsResponse, error)
+	// Needs the toasts:write permission
+	CreateToast(ctx context.Context, in *CreateToastRequest, opts ...grpc.CallOption) (*Toast, error)
+	// Needs toasts:write. The version must be the one the client has seen
+	UpdateToast(ctx context.Context, in *UpdateToastRequest, opts ...grpc.CallOption) (*Toast, error)
+	// Needs toasts:write. The version must be the one the client has seen
+	DeleteToast(ctx context.Context, in *DeleteToastRequest, opts ...grpc.CallOption) (*DeleteToastResponse, error)
+}
+
+type toastServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewToastServiceClient(cc grpc.ClientConnInterface) ToastServiceClient {
+	return &toastServiceClient{cc}
+}
+
+func (c *toastServiceClient) GetToast(ctx context.Context, in *GetToastRequest, opts ...grpc.CallOption) (*Toast, error) {
+	out := new(Toast)
+	err := c.cc.Invoke(ctx, ToastService_GetToast_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *toastServiceClient) ListToasts(ctx context.Context, in *ListToastsRequest, opts ...grpc.CallOption) (*ListToastsResponse, error) {
+	out := new(ListToastsResponse)
+	err := c.cc.Invoke(ctx, ToastService_ListToasts_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *toastServiceClient) CreateToast(ctx context.Context, in *CreateToastRequest, opts ...grpc.CallOption) (*Toast, error) {
+	out := new(Toast)
+	err := c.cc.Invoke(ctx, ToastService_CreateToast_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *toastServiceClient) UpdateToast(ctx context.Context, in *UpdateToastRequest, opts ...grpc.CallOption) (*Toast, error) {
+	out := new(Toast)
+	err := c.cc.Invoke(ctx, ToastService_UpdateToast_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *toastServiceClient) DeleteToast(ctx context.Context, in *DeleteToastRequest, opts ...grpc.CallOption) (*DeleteToastResponse, error) {
+	out := new(DeleteToastResponse)
+	err := c.cc.Invoke(ctx, ToastService_DeleteToast_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ToastServiceServer is the server API for ToastService service.
+// All implementations must embed UnimplementedToastServiceServer
+// for forward compatibility
+type ToastServiceServer interface {
+	// Needs the toasts:read permission
+	GetToast(context.Context, *GetToastRequest) (*Toast, error)
+	// Takes the parameters of "GET /v1/toasts". Needs toasts:read
+	ListToasts(context.Context, *ListToastsRequest) (*ListToastsResponse, error)
+	// Needs the toasts:write permission
+	CreateToast(context.Context, *CreateToastRequest) (*Toast, error)
+	// Needs toasts:write. The version must be the one the client has seen
+	UpdateToast(context.Context, *UpdateToastRequest) (*Toast, error)
+	// Needs toasts:write. The version must be the one the client has seen
+	DeleteToast(context.Context, *DeleteToastRequest) (*DeleteToastResponse, error)
+	mustEmbedUnimplementedToastServiceServer()
+}
+
+// UnimplementedToastServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedToastServiceServer struct {
+}
+
+func (UnimplementedToastServiceServer) GetToast(context.Context, *GetToastRequest) (*Toast, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetToast not implemented")
+}
+func (UnimplementedToastServiceServer) ListToasts(context.Context, *ListToastsRequest) (*ListToastsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListToasts not implemented")
+}
+func (UnimplementedToastServiceServer) CreateToast(context.Context, *CreateToastRequest) (*Toast, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateToast not implemented")
+}
+func (UnimplementedToastServiceServer) UpdateToast(context.Context, *UpdateToastRequest) (*Toast, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateToast not implemented")
+}
+func (UnimplementedToastServiceServer) DeleteToast(context.Context, *DeleteToastRequest) (*DeleteToastResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteToast not implemented")
+}
+func (UnimplementedToastServiceServer) mustEmbedUnimplementedToastServiceServer() {}
+
+// UnsafeToastServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ToastServiceServer will
+// result in compilation errors.
+type UnsafeToastServiceServer interface {
+	mustEmbedUnimplementedToastServiceServer()
+}
+
+func RegisterToastServiceServer(s grpc.ServiceRegistrar, srv ToastServiceServer) {
+	s.RegisterService(&ToastService_ServiceDesc, srv)
+}
+
+func _ToastService_GetToast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetToastRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ToastServiceServer).GetToast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ToastService_GetToast_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ToastServiceServer).GetToast(ctx, req.(*GetToastRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ToastService_ListToasts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListToastsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ToastServiceServer).ListToasts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ToastService_ListToasts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ToastServiceServer).ListToasts(ctx, req.(*ListToastsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ToastService_CreateToast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateToastRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ToastServiceServer).CreateToast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ToastService_CreateToast_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ToastServiceServer).CreateToast(ctx, req.(*CreateToastRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ToastService_UpdateToast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateToastRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ToastServiceServer).UpdateToast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ToastService_UpdateToast_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ToastServiceServer).UpdateToast(ctx, req.(*UpdateToastRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ToastService_DeleteToast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteToastRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ToastServiceServer).DeleteToast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ToastService_DeleteToast_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ToastServiceServer).DeleteToast(ctx, req.(*DeleteToastRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ToastService_ServiceDesc is the grpc.ServiceDesc for ToastService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ToastService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "toaster.v1.ToastService",
+	HandlerType: (*ToastServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetToast",
+			Handler:    _ToastService_GetToast_Handler,
+		},
+		{
+			MethodName: "ListToasts",
+			Handler:    _ToastService_ListToasts_Handler,
+		},
+		{
+			MethodName: "CreateToast",
+			Handler:    _ToastService_CreateToast_Handler,
+		},
+		{
+			MethodName: "UpdateToast",
+			Handler:    _ToastService_UpdateToast_Handler,
+		},
+		{
+			MethodName: "DeleteToast",
+			Handler:    _ToastService_DeleteToast_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "toaster.proto",
+}
+
+const (
+	TokenService_CreateAuthenticationToken_FullMethodName = "/toaster.v1.TokenService/CreateAuthenticationToken"
+)
+
+// TokenServiceClient is the client API for TokenService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TokenServiceClient interface {
+	CreateAuthenticationToken(ctx context.Context, in *CreateAuthenticationTokenRequest, opts ...grpc.CallOption) (*AuthenticationToken, error)
+}
+
+type tokenServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTokenServiceClient(cc grpc.ClientConnInterface) TokenServiceClient {
+	return &tokenServiceClient{cc}
+}
+
+func (c *tokenServiceClient) CreateAuthenticationToken(ctx context.Context, in *CreateAuthenticationTokenRequest, opts ...grpc.CallOption) (*AuthenticationToken, error) {
+	out := new(AuthenticationToken)
+	err := c.cc.Invoke(ctx, TokenService_CreateAuthenticationToken_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TokenServiceServer is the server API for TokenService service.
+// All implementations must embed UnimplementedTokenServiceServer
+// for forward compatibility
+type TokenServiceServer interface {
+	CreateAuthenticationToken(context.Context, *CreateAuthenticationTokenRequest) (*AuthenticationToken, error)
+	mustEmbedUnimplementedTokenServiceServer()
+}
+
+// UnimplementedTokenServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedTokenServiceServer struct {
+}
+
+func (UnimplementedTokenServiceServer) CreateAuthenticationToken(context.Context, *CreateAuthenticationTokenRequest) (*AuthenticationToken, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAuthenticationToken not implemented")
+}
+func (UnimplementedTokenServiceServer) mustEmbedUnimplementedTokenServiceServer() {}
+
+// UnsafeTokenServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TokenServiceServer will
+// result in compilation errors.
+type UnsafeTokenServiceServer interface {
+	mustEmbedUnimplementedTokenServiceServer()
+}
+
+func RegisterTokenServiceServer(s grpc.ServiceRegistrar, srv TokenServiceServer) {
+	s.RegisterService(&TokenService_ServiceDesc, srv)
+}
+
+func _TokenService_CreateAuthenticationToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAuthenticationTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServiceServer).CreateAuthenticationToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenService_CreateAuthenticationToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServiceServer).CreateAuthenticationToken(ctx, req.(*CreateAuthenticationTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TokenService_ServiceDesc is the grpc.ServiceDesc for TokenService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TokenService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "toaster.v1.TokenService",
+	HandlerType: (*TokenServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateAuthenticationToken",
+			Handler:    _TokenService_CreateAuthenticationToken_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "toaster.proto",
+}
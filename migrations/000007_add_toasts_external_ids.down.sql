@@ -0,0 +1,5 @@
+-- Filename: migrations/000007_add_toasts_external_ids.down.sql
+DROP INDEX IF EXISTS toasts_external_idx;
+ALTER TABLE toasts DROP CONSTRAINT IF EXISTS external_source_check;
+ALTER TABLE toasts DROP COLUMN IF EXISTS external_id;
+ALTER TABLE toasts DROP COLUMN IF EXISTS external_source;
\ No newline at end of file
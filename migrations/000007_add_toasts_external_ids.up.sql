@@ -0,0 +1,7 @@
+-- Filename: migrations/000007_add_toasts_external_ids.up.sql
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS external_source text NOT NULL DEFAULT '';
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS external_id text NOT NULL DEFAULT '';
+ALTER TABLE toasts ADD CONSTRAINT external_source_check CHECK (external_id = '' OR external_source <> '');
+
+-- an external id is unique within its source
+CREATE UNIQUE INDEX IF NOT EXISTS toasts_external_idx ON toasts (external_source, external_id) WHERE external_id <> '';
\ No newline at end of file
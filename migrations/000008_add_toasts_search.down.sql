@@ -0,0 +1,3 @@
+-- Filename: migrations/000008_add_toasts_search.down.sql
+DROP INDEX IF EXISTS toasts_search_idx;
+ALTER TABLE toasts DROP COLUMN IF EXISTS search;
\ No newline at end of file
@@ -0,0 +1,10 @@
+-- Filename: migrations/000008_add_toasts_search.up.sql
+-- a weighted document over the searchable fields, kept up to date by postgres
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', name), 'A') ||
+    setweight(to_tsvector('simple', level), 'B') ||
+    setweight(to_tsvector('simple', contact), 'C') ||
+    setweight(to_tsvector('simple', address), 'C') ||
+    setweight(to_tsvector('simple', website), 'D')
+) STORED;
+CREATE INDEX IF NOT EXISTS toasts_search_idx ON toasts USING GIN(search);
\ No newline at end of file
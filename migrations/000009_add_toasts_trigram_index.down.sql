@@ -0,0 +1,2 @@
+-- Filename: migrations/000009_add_toasts_trigram_index.down.sql
+DROP INDEX IF EXISTS toasts_name_trgm_idx;
\ No newline at end of file
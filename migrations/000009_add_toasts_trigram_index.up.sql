@@ -0,0 +1,3 @@
+-- Filename: migrations/000009_add_toasts_trigram_index.up.sql
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS toasts_name_trgm_idx ON toasts USING GIN(name gin_trgm_ops);
\ No newline at end of file
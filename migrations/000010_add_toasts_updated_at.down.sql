@@ -0,0 +1,4 @@
+-- Filename: migrations/000010_add_toasts_updated_at.down.sql
+DROP INDEX IF EXISTS toasts_updated_at_idx;
+DROP INDEX IF EXISTS toasts_created_at_idx;
+ALTER TABLE toasts DROP COLUMN IF EXISTS updated_at;
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- Filename: migrations/000010_add_toasts_updated_at.up.sql
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+UPDATE toasts SET updated_at = created_at;
+CREATE INDEX IF NOT EXISTS toasts_created_at_idx ON toasts (created_at, id);
+CREATE INDEX IF NOT EXISTS toasts_updated_at_idx ON toasts (updated_at, id);
\ No newline at end of file
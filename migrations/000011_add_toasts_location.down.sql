@@ -0,0 +1,5 @@
+-- Filename: migrations/000011_add_toasts_location.down.sql
+DROP INDEX IF EXISTS toasts_location_idx;
+ALTER TABLE toasts DROP CONSTRAINT IF EXISTS location_check;
+ALTER TABLE toasts DROP COLUMN IF EXISTS longitude;
+ALTER TABLE toasts DROP COLUMN IF EXISTS latitude;
\ No newline at end of file
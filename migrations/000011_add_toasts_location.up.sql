@@ -0,0 +1,11 @@
+-- Filename: migrations/000011_add_toasts_location.up.sql
+CREATE EXTENSION IF NOT EXISTS cube;
+CREATE EXTENSION IF NOT EXISTS earthdistance;
+
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS latitude double precision;
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS longitude double precision;
+ALTER TABLE toasts ADD CONSTRAINT location_check CHECK (
+    (latitude IS NULL AND longitude IS NULL) OR
+    (latitude BETWEEN -90 AND 90 AND longitude BETWEEN -180 AND 180)
+);
+CREATE INDEX IF NOT EXISTS toasts_location_idx ON toasts USING GIST(ll_to_earth(latitude, longitude));
\ No newline at end of file
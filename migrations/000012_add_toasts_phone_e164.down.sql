@@ -0,0 +1,3 @@
+-- Filename: migrations/000012_add_toasts_phone_e164.down.sql
+DROP INDEX IF EXISTS toasts_phone_e164_idx;
+ALTER TABLE toasts DROP COLUMN IF EXISTS phone_e164;
\ No newline at end of file
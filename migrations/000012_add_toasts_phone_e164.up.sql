@@ -0,0 +1,5 @@
+-- Filename: migrations/000012_add_toasts_phone_e164.up.sql
+-- Existing rows are left empty and filled in by the API's backfill job, run
+-- once by starting it with -phone-backfill
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS phone_e164 text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS toasts_phone_e164_idx ON toasts (phone_e164);
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- Filename: migrations/000013_create_toast_vocabularies.down.sql
+DELETE FROM permissions WHERE code = 'vocabularies:write';
+DROP TABLE IF EXISTS toast_modes;
+DROP TABLE IF EXISTS toast_levels;
\ No newline at end of file
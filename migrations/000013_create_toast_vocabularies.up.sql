@@ -0,0 +1,54 @@
+-- Filename: migrations/000013_create_toast_vocabularies.up.sql
+CREATE TABLE IF NOT EXISTS toast_levels (
+    name text PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS toast_levels_name_lower_idx ON toast_levels (lower(name));
+
+CREATE TABLE IF NOT EXISTS toast_modes (
+    name text PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS toast_modes_name_lower_idx ON toast_modes (lower(name));
+
+-- Tidy the whitespace of the existing values
+UPDATE toasts SET
+    level = regexp_replace(trim(level), '\s+', ' ', 'g'),
+    mode = ARRAY(SELECT regexp_replace(trim(m), '\s+', ' ', 'g') FROM unnest(mode) WITH ORDINALITY AS u(m, i) ORDER BY i);
+
+-- Each value becomes a term, spelled the way most toasts spell it when
+-- case is ignored
+INSERT INTO toast_levels (name)
+SELECT DISTINCT ON (lower(level)) level
+FROM toasts
+GROUP BY level
+ORDER BY lower(level), count(*) DESC, level
+ON CONFLICT DO NOTHING;
+
+INSERT INTO toast_modes (name)
+SELECT DISTINCT ON (lower(m)) m
+FROM toasts, unnest(mode) AS m
+GROUP BY m
+ORDER BY lower(m), count(*) DESC, m
+ON CONFLICT DO NOTHING;
+
+-- Map the existing values to the terms, dropping modes that become
+-- duplicates
+UPDATE toasts SET level = toast_levels.name
+FROM toast_levels
+WHERE lower(toasts.level) = lower(toast_levels.name)
+AND toasts.level <> toast_levels.name;
+
+UPDATE toasts SET mode = ARRAY(
+    SELECT name FROM (
+        SELECT DISTINCT ON (toast_modes.name) toast_modes.name, u.i
+        FROM unnest(toasts.mode) WITH ORDINALITY AS u(m, i)
+        JOIN toast_modes ON lower(toast_modes.name) = lower(u.m)
+        ORDER BY toast_modes.name, u.i
+    ) AS terms
+    ORDER BY i
+);
+
+-- Only admins change the vocabularies
+INSERT INTO permissions (code)
+VALUES ('vocabularies:write');
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- Filename: migrations/000014_create_toast_contacts_table.down.sql
+DROP TABLE IF EXISTS toast_contacts;
\ No newline at end of file
@@ -0,0 +1,21 @@
+-- Filename: migrations/000014_create_toast_contacts_table.up.sql
+CREATE TABLE IF NOT EXISTS toast_contacts (
+    id bigserial PRIMARY KEY,
+    toast_id bigint NOT NULL REFERENCES toasts (id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    role text NOT NULL DEFAULT '',
+    phone text NOT NULL DEFAULT '',
+    phone_e164 text NOT NULL DEFAULT '',
+    email text NOT NULL DEFAULT '',
+    is_primary boolean NOT NULL DEFAULT FALSE,
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS toast_contacts_toast_id_idx ON toast_contacts (toast_id);
+-- A toast has at most one primary contact
+CREATE UNIQUE INDEX IF NOT EXISTS toast_contacts_primary_idx ON toast_contacts (toast_id) WHERE is_primary;
+
+-- The contact details each toast already has become its primary contact
+INSERT INTO toast_contacts (toast_id, name, phone, phone_e164, email, is_primary)
+SELECT id, contact, phone, phone_e164, email, TRUE
+FROM toasts;
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- Filename: migrations/000015_add_toasts_duplicate_detection.down.sql
+DELETE FROM permissions WHERE code = 'toasts:admin';
+DROP INDEX IF EXISTS toasts_website_domain_idx;
+DROP INDEX IF EXISTS toasts_email_lower_idx;
+DROP FUNCTION IF EXISTS website_domain(text);
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- Filename: migrations/000015_add_toasts_duplicate_detection.up.sql
+-- The host of a website without a leading www., so http://www.a.org/x and
+-- https://a.org are on the same domain
+CREATE OR REPLACE FUNCTION website_domain(website text) RETURNS text AS $$
+    SELECT substring(lower(website) from '^[a-z][a-z0-9+.-]*://(?:www\.)?([^/:?#]+)')
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE INDEX IF NOT EXISTS toasts_email_lower_idx ON toasts (lower(email));
+CREATE INDEX IF NOT EXISTS toasts_website_domain_idx ON toasts (website_domain(website));
+
+-- Reviewing and merging duplicates is for admins
+INSERT INTO permissions (code)
+VALUES ('toasts:admin');
\ No newline at end of file
@@ -0,0 +1,7 @@
+-- Filename: migrations/000016_add_toasts_tags_and_attributes.down.sql
+DROP TABLE IF EXISTS toast_attributes;
+DROP INDEX IF EXISTS toasts_attributes_idx;
+DROP INDEX IF EXISTS toasts_tags_idx;
+ALTER TABLE toasts DROP CONSTRAINT IF EXISTS attributes_object_check;
+ALTER TABLE toasts DROP COLUMN IF EXISTS attributes;
+ALTER TABLE toasts DROP COLUMN IF EXISTS tags;
\ No newline at end of file
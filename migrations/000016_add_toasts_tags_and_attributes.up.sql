@@ -0,0 +1,16 @@
+-- Filename: migrations/000016_add_toasts_tags_and_attributes.up.sql
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS tags text[] NOT NULL DEFAULT '{}';
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS attributes jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE toasts ADD CONSTRAINT attributes_object_check CHECK (jsonb_typeof(attributes) = 'object');
+CREATE INDEX IF NOT EXISTS toasts_tags_idx ON toasts USING GIN(tags);
+CREATE INDEX IF NOT EXISTS toasts_attributes_idx ON toasts USING GIN(attributes);
+
+-- The attributes a toast may have, defined by admins
+CREATE TABLE IF NOT EXISTS toast_attributes (
+    name text PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    type text NOT NULL CHECK (type IN ('string', 'number', 'boolean')),
+    required boolean NOT NULL DEFAULT FALSE,
+    enum text[] NOT NULL DEFAULT '{}',
+    version integer NOT NULL DEFAULT 1
+);
\ No newline at end of file
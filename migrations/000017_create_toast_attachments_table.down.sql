@@ -0,0 +1,2 @@
+-- Filename: migrations/000017_create_toast_attachments_table.down.sql
+DROP TABLE IF EXISTS toast_attachments;
@@ -0,0 +1,12 @@
+-- Filename: migrations/000017_create_toast_attachments_table.up.sql
+-- The contents of attachments are kept in file storage under storage_key
+CREATE TABLE IF NOT EXISTS toast_attachments (
+    id bigserial PRIMARY KEY,
+    toast_id bigint NOT NULL REFERENCES toasts (id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    filename text NOT NULL,
+    content_type text NOT NULL,
+    size bigint NOT NULL,
+    storage_key text NOT NULL UNIQUE
+);
+CREATE INDEX IF NOT EXISTS toast_attachments_toast_id_idx ON toast_attachments (toast_id);
@@ -0,0 +1,3 @@
+-- Filename: migrations/000018_add_toasts_opening_hours.down.sql
+DROP FUNCTION IF EXISTS toast_is_open(jsonb, timestamp with time zone);
+ALTER TABLE toasts DROP COLUMN IF EXISTS hours;
@@ -0,0 +1,25 @@
+-- Filename: migrations/000018_add_toasts_opening_hours.up.sql
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS hours jsonb;
+
+-- Whether a toast with the opening hours is open at a moment. A date in the
+-- exceptions takes the place of the weekly hours for that day. This must
+-- agree with OpeningHours.OpenAt()
+CREATE OR REPLACE FUNCTION toast_is_open(hours jsonb, moment timestamp with time zone) RETURNS boolean AS $$
+    SELECT EXISTS (
+        SELECT 1
+        FROM (SELECT moment AT TIME ZONE (hours->>'time_zone') AS t) AS here,
+        LATERAL (
+            SELECT COALESCE(
+                (SELECT e->'intervals'
+                 FROM jsonb_array_elements(COALESCE(hours->'exceptions', '[]')) AS e
+                 WHERE e->>'date' = to_char(here.t, 'YYYY-MM-DD')
+                 LIMIT 1),
+                hours->'weekly'->((ARRAY['monday', 'tuesday', 'wednesday', 'thursday',
+                                         'friday', 'saturday', 'sunday'])[EXTRACT(ISODOW FROM here.t)::int])
+            ) AS intervals
+        ) AS today,
+        jsonb_array_elements(CASE WHEN jsonb_typeof(today.intervals) = 'array' THEN today.intervals ELSE '[]' END) AS i
+        WHERE (i->>'open')::time <= here.t::time
+        AND here.t::time < (i->>'close')::time
+    )
+$$ LANGUAGE SQL STABLE;
@@ -0,0 +1,2 @@
+-- Filename: migrations/000019_create_toast_translations_table.down.sql
+DROP TABLE IF EXISTS toast_translations;
@@ -0,0 +1,20 @@
+-- Filename: migrations/000019_create_toast_translations_table.up.sql
+-- The name and address of a toast in other languages than its own
+CREATE TABLE IF NOT EXISTS toast_translations (
+    toast_id bigint NOT NULL REFERENCES toasts (id) ON DELETE CASCADE,
+    locale text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    address text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    -- searched alongside the toast's own search document
+    search tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', name), 'A') ||
+        setweight(to_tsvector('simple', address), 'C')
+    ) STORED,
+    PRIMARY KEY (toast_id, locale)
+);
+CREATE INDEX IF NOT EXISTS toast_translations_search_idx ON toast_translations USING GIN(search);
+CREATE INDEX IF NOT EXISTS toast_translations_name_idx ON toast_translations USING GIN(to_tsvector('simple', name));
+CREATE INDEX IF NOT EXISTS toast_translations_name_trgm_idx ON toast_translations USING GIN(name gin_trgm_ops);
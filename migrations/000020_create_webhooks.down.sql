@@ -0,0 +1,4 @@
+-- Filename: migrations/000020_create_webhooks.down.sql
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS toast_events;
@@ -0,0 +1,39 @@
+-- Filename: migrations/000020_create_webhooks.up.sql
+-- Every change to a toast, written in the same transaction as the change.
+-- The payload is the toast as it was after the change, or before its removal
+CREATE TABLE IF NOT EXISTS toast_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    type text NOT NULL,
+    toast_id bigint NOT NULL,
+    payload jsonb NOT NULL
+);
+CREATE INDEX IF NOT EXISTS toast_events_toast_id_idx ON toast_events (toast_id);
+
+-- The URLs toast events are posted to. No events means all of them
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL DEFAULT '{}',
+    active boolean NOT NULL DEFAULT TRUE,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- One row for each event a webhook is sent, with the result of the latest
+-- attempt. Pending deliveries are retried until next_attempt_at
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_id bigint NOT NULL REFERENCES toast_events (id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_status_code integer NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    delivered_at timestamp(0) with time zone
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,3 @@
+-- Filename: migrations/000021_add_toasts_notify_trigger.down.sql
+DROP TRIGGER IF EXISTS toasts_notify_changes ON toasts;
+DROP FUNCTION IF EXISTS notify_toast_changes();
@@ -0,0 +1,16 @@
+-- Filename: migrations/000021_add_toasts_notify_trigger.up.sql
+-- Tell the listening API servers that toasts changed. The notification only
+-- wakes them: the changes themselves are read from toast_events, and
+-- Postgres sends it once the transaction commits
+CREATE OR REPLACE FUNCTION notify_toast_changes() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('toast_changes', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS toasts_notify_changes ON toasts;
+CREATE TRIGGER toasts_notify_changes
+    AFTER INSERT OR UPDATE OR DELETE ON toasts
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_toast_changes();
@@ -0,0 +1,2 @@
+-- Filename: migrations/000022_add_toasts_created_by.down.sql
+ALTER TABLE toasts DROP COLUMN IF EXISTS created_by;
@@ -0,0 +1,4 @@
+-- Filename: migrations/000022_add_toasts_created_by.up.sql
+-- The user who created each toast. Toasts created before this are left
+-- without one
+ALTER TABLE toasts ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users (id) ON DELETE SET NULL;
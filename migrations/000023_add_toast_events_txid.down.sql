@@ -0,0 +1,3 @@
+-- Filename: migrations/000023_add_toast_events_txid.down.sql
+DROP INDEX IF EXISTS toast_events_position_idx;
+ALTER TABLE toast_events DROP COLUMN IF EXISTS txid;
@@ -0,0 +1,6 @@
+-- Filename: migrations/000023_add_toast_events_txid.up.sql
+-- The transaction that wrote each event. Readers only see the events of
+-- transactions older than every one still running, in (txid, id) order, so
+-- an event never turns up behind one a reader has already passed
+ALTER TABLE toast_events ADD COLUMN IF NOT EXISTS txid bigint NOT NULL DEFAULT txid_current();
+CREATE INDEX IF NOT EXISTS toast_events_position_idx ON toast_events (txid, id);
@@ -0,0 +1,3 @@
+-- Filename: migrations/000024_map_toast_vocabulary_variants.down.sql
+-- The toasts keep their terms: the spellings they replaced are not stored
+SELECT 1;
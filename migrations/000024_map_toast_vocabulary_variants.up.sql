@@ -0,0 +1,69 @@
+-- Filename: migrations/000024_map_toast_vocabulary_variants.up.sql
+-- Migration 000013 only merged values that differ by case. These are the
+-- other ways the existing toasts spell the levels and modes, each mapped to
+-- its term. Variants are matched in lower case with single spaces
+CREATE TEMPORARY TABLE level_variants (variant text PRIMARY KEY, term text NOT NULL);
+INSERT INTO level_variants (variant, term) VALUES
+    ('primary school', 'primary'),
+    ('elementary', 'primary'),
+    ('elementary school', 'primary'),
+    ('secondary school', 'secondary'),
+    ('high school', 'secondary'),
+    ('highschool', 'secondary'),
+    ('junior college', 'tertiary'),
+    ('university', 'tertiary'),
+    ('preschool', 'pre-school'),
+    ('pre school', 'pre-school');
+
+CREATE TEMPORARY TABLE mode_variants (variant text PRIMARY KEY, term text NOT NULL);
+INSERT INTO mode_variants (variant, term) VALUES
+    ('face to face', 'face-to-face'),
+    ('in person', 'face-to-face'),
+    ('in-person', 'face-to-face'),
+    ('on-site', 'face-to-face'),
+    ('on line', 'online'),
+    ('virtual', 'online'),
+    ('remote', 'online'),
+    ('blended', 'hybrid');
+
+-- Add the terms the variants map to, unless a spelling of them is there
+INSERT INTO toast_levels (name)
+SELECT DISTINCT v.term
+FROM toasts
+JOIN level_variants AS v ON v.variant = lower(toasts.level)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO toast_modes (name)
+SELECT DISTINCT v.term
+FROM toasts, unnest(toasts.mode) AS m
+JOIN mode_variants AS v ON v.variant = lower(m)
+ON CONFLICT DO NOTHING;
+
+-- Map the toasts to the terms as they are spelled, dropping modes that
+-- become duplicates
+UPDATE toasts SET level = toast_levels.name
+FROM level_variants AS v
+JOIN toast_levels ON lower(toast_levels.name) = v.term
+WHERE lower(toasts.level) = v.variant;
+
+UPDATE toasts SET mode = ARRAY(
+    SELECT name FROM (
+        SELECT DISTINCT ON (toast_modes.name) toast_modes.name, u.i
+        FROM unnest(toasts.mode) WITH ORDINALITY AS u(m, i)
+        LEFT JOIN mode_variants AS v ON v.variant = lower(u.m)
+        JOIN toast_modes ON lower(toast_modes.name) = COALESCE(v.term, lower(u.m))
+        ORDER BY toast_modes.name, u.i
+    ) AS terms
+    ORDER BY i
+)
+WHERE EXISTS (SELECT 1 FROM unnest(toasts.mode) AS m JOIN mode_variants AS v ON v.variant = lower(m));
+
+-- The variants are no longer terms
+DELETE FROM toast_levels
+WHERE lower(name) IN (SELECT variant FROM level_variants);
+
+DELETE FROM toast_modes
+WHERE lower(name) IN (SELECT variant FROM mode_variants);
+
+DROP TABLE level_variants;
+DROP TABLE mode_variants;
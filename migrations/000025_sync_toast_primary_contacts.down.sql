@@ -0,0 +1,3 @@
+-- Filename: migrations/000025_sync_toast_primary_contacts.down.sql
+-- The synced contacts are kept
+SELECT 1;
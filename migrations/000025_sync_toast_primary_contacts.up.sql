@@ -0,0 +1,20 @@
+-- Filename: migrations/000025_sync_toast_primary_contacts.up.sql
+-- A toast's contact details and its primary contact's were written apart
+-- and may differ. The toast's, which clients have been reading, win
+UPDATE toast_contacts
+SET name = toasts.contact, phone = toasts.phone, phone_e164 = toasts.phone_e164,
+    email = toasts.email, version = toast_contacts.version + 1
+FROM toasts
+WHERE toast_contacts.toast_id = toasts.id
+AND toast_contacts.is_primary
+AND (toast_contacts.name, toast_contacts.phone, toast_contacts.phone_e164, toast_contacts.email)
+    IS DISTINCT FROM (toasts.contact, toasts.phone, toasts.phone_e164, toasts.email);
+
+-- Every toast has a primary contact
+INSERT INTO toast_contacts (toast_id, name, phone, phone_e164, email, is_primary)
+SELECT id, contact, phone, phone_e164, email, TRUE
+FROM toasts
+WHERE NOT EXISTS (
+    SELECT 1 FROM toast_contacts
+    WHERE toast_contacts.toast_id = toasts.id AND toast_contacts.is_primary
+);